@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var ErrAlbumNotFound = errors.New("album not found")
+
+type AlbumQueueRequest struct {
+	SpotifyAlbumID string `json:"spotify_album_id"`
+	// "play_now", "normal" (default) or "prefetch"
+	Priority string `json:"priority"`
+}
+
+type AlbumQueueResult struct {
+	SpotifyAlbumID string             `json:"spotify_album_id"`
+	Name           string             `json:"name"`
+	Total          int                `json:"total"`
+	Created        int                `json:"created"`
+	Skipped        int                `json:"skipped"`
+	Results        []BatchQueueResult `json:"results"`
+}
+
+// AlbumStatus is the aggregated download status of an album's tracks
+type AlbumStatus struct {
+	SpotifyAlbumID string         `json:"spotify_album_id"`
+	Name           string         `json:"name"`
+	Status         string         `json:"status"`
+	Total          int            `json:"total"`
+	Counts         map[string]int `json:"counts"`
+}
+
+// QueueAlbum creates queued track records for every track of the album.
+// Tracks that are already present are skipped.
+func QueueAlbum(ctx context.Context, app core.App, payload AlbumQueueRequest) (*AlbumQueueResult, error) {
+	if payload.SpotifyAlbumID == "" {
+		return nil, fmt.Errorf("%w: spotify_album_id is required", ErrInvalidRequest)
+	}
+
+	priority, err := ParsePriority(payload.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, batchMetadataTimeout)
+	defer cancel()
+
+	album, tracks, err := fetchSpotifyAlbumTracks(ctx, payload.SpotifyAlbumID)
+	if err != nil {
+		return nil, classifySpotifyError(err)
+	}
+
+	results, err := createTrackRecords(app, tracks, priority)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AlbumQueueResult{
+		SpotifyAlbumID: album.ID,
+		Name:           album.Name,
+		Total:          len(results),
+		Results:        results,
+	}
+	for _, r := range results {
+		if r.Status == QueueCreated {
+			result.Created++
+		} else {
+			result.Skipped++
+		}
+	}
+
+	return result, nil
+}
+
+// createTrackRecords creates queued records for the tracks that aren't
+// present yet (in a single transaction). Present ones are left untouched.
+func createTrackRecords(app core.App, tracks []*SpotifyTrack, priority int) ([]BatchQueueResult, error) {
+	ids := make([]any, 0, len(tracks))
+	for _, t := range tracks {
+		ids = append(ids, t.ID)
+	}
+
+	existingByID := map[string]*core.Record{}
+	if len(ids) > 0 {
+		existingTracks, err := app.FindAllRecords("tracks", dbx.In("spotify_track_id", ids...))
+		if err != nil {
+			return nil, err
+		}
+		for _, track := range existingTracks {
+			existingByID[track.GetString("spotify_track_id")] = track
+		}
+	}
+
+	results := make([]BatchQueueResult, 0, len(tracks))
+	err := app.RunInTransaction(func(txApp core.App) error {
+		for _, t := range tracks {
+			if existing, ok := existingByID[t.ID]; ok {
+				results = append(results, BatchQueueResult{SpotifyTrackID: t.ID, Status: QueueExisting, TrackID: existing.Id})
+				continue
+			}
+
+			record, err := saveTrackRecord(txApp, t.Metadata(), priority)
+			if err != nil {
+				return fmt.Errorf("track save error: %w", err)
+			}
+			existingByID[t.ID] = record // the same track can appear twice (e.g. across pages)
+
+			results = append(results, BatchQueueResult{SpotifyTrackID: t.ID, Status: QueueCreated, TrackID: record.Id})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	NotifyQueue()
+
+	return results, nil
+}
+
+// fetchSpotifyAlbumTracks fetches the album and pages through all of its tracks.
+// The album only lists simplified track objects (no ISRC, no popularity), so the
+// full tracks are fetched afterwards in batches.
+func fetchSpotifyAlbumTracks(ctx context.Context, albumID string) (*SpotifyAlbum, []*SpotifyTrack, error) {
+	var data struct {
+		SpotifyAlbum
+		Tracks spotifyTrackPage `json:"tracks"`
+	}
+	if err := Spotify().get(ctx, "/albums/"+url.PathEscape(albumID), &data); err != nil {
+		return nil, nil, err
+	}
+
+	album := data.SpotifyAlbum
+	page := data.Tracks
+	tracks := []*SpotifyTrack{}
+	for {
+		for _, t := range page.Items {
+			// album tracks come without the album object
+			t.Album = album
+			tracks = append(tracks, t)
+		}
+
+		if page.Next == "" {
+			break
+		}
+
+		next := page.Next
+		page = spotifyTrackPage{}
+		if err := Spotify().get(ctx, next, &page); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ids := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		ids = append(ids, t.ID)
+	}
+	fullTracks, err := fetchSpotifyTracks(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, t := range tracks {
+		if full, ok := fullTracks[t.ID]; ok {
+			full.Album = album
+			tracks[i] = full
+		}
+	}
+
+	return &album, tracks, nil
+}
+
+type spotifyTrackPage struct {
+	Items []*SpotifyTrack `json:"items"`
+	Next  string          `json:"next"`
+}
+
+// ======================================================================
+//  ALBUM STATUS
+// ======================================================================
+
+// GetAlbumStatus aggregates the download status of all stored tracks of the album
+func GetAlbumStatus(app core.App, albumID string) (*AlbumStatus, error) {
+	tracks, err := app.FindAllRecords("tracks", dbx.HashExp{"album_id": albumID})
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, ErrAlbumNotFound
+	}
+
+	status := &AlbumStatus{
+		SpotifyAlbumID: albumID,
+		Name:           tracks[0].GetString("album"),
+		Total:          len(tracks),
+		Counts:         map[string]int{},
+	}
+	for _, track := range tracks {
+		status.Counts[track.GetString("download_status")]++
+	}
+
+	pending := status.Counts["queued"] + status.Counts["downloading"]
+	completed := status.Counts["completed"]
+
+	switch {
+	case completed == status.Total:
+		status.Status = "completed"
+	case status.Counts["queued"] == status.Total:
+		status.Status = "queued"
+	case pending > 0:
+		status.Status = "downloading"
+	case completed > 0:
+		// nothing left to do, but some tracks didn't make it
+		status.Status = "partial"
+	default:
+		status.Status = "failed"
+	}
+
+	return status, nil
+}
@@ -0,0 +1,199 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// How long walking (and queueing) a whole discography may take - less than
+// the HTTP server's 5 minute write timeout, so the client still gets the result
+const artistQueueTimeout = 4 * time.Minute
+
+var artistIncludeGroups = []string{"album", "single", "compilation", "appears_on"}
+
+var defaultArtistIncludeGroups = []string{"album", "single"}
+
+type ArtistQueueRequest struct {
+	SpotifyArtistID string `json:"spotify_artist_id"`
+	// Any of "album", "single", "compilation", "appears_on" (default: album, single)
+	IncludeGroups []string `json:"include_groups"`
+	// "play_now", "normal", "prefetch" (default)
+	Priority string `json:"priority"`
+}
+
+type ArtistQueueResult struct {
+	SpotifyArtistID string `json:"spotify_artist_id"`
+	Releases        int    `json:"releases"`
+	// Unique tracks after the ISRC dedupe
+	Total          int `json:"total"`
+	Duplicates     int `json:"duplicates"`
+	New            int `json:"new"`
+	Retried        int `json:"retried"`
+	AlreadyPresent int `json:"already_present"`
+	Failed         int `json:"failed"`
+}
+
+// QueueArtist queues the artist's discography. Tracks released on multiple
+// releases (e.g. album + single) are deduplicated by ISRC, against each other
+// and against the already stored tracks.
+func QueueArtist(ctx context.Context, app core.App, payload ArtistQueueRequest) (*ArtistQueueResult, error) {
+	if payload.SpotifyArtistID == "" {
+		return nil, fmt.Errorf("%w: spotify_artist_id is required", ErrInvalidRequest)
+	}
+
+	groups := payload.IncludeGroups
+	if len(groups) == 0 {
+		groups = defaultArtistIncludeGroups
+	}
+	for _, g := range groups {
+		if !slices.Contains(artistIncludeGroups, g) {
+			return nil, fmt.Errorf("%w: unknown include group %q", ErrInvalidRequest, g)
+		}
+	}
+
+	if payload.Priority == "" {
+		payload.Priority = "prefetch"
+	}
+	priority, err := ParsePriority(payload.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, artistQueueTimeout)
+	defer cancel()
+
+	albumIDs, err := fetchSpotifyArtistAlbumIDs(ctx, payload.SpotifyArtistID, groups)
+	if err != nil {
+		return nil, classifySpotifyError(err)
+	}
+
+	result := &ArtistQueueResult{
+		SpotifyArtistID: payload.SpotifyArtistID,
+		Releases:        len(albumIDs),
+	}
+
+	// Collect the tracks, in release order
+	tracks := []*SpotifyTrack{}
+	for _, albumID := range albumIDs {
+		_, albumTracks, err := fetchSpotifyAlbumTracks(ctx, albumID)
+		if err != nil {
+			return nil, classifySpotifyError(err)
+		}
+
+		for _, t := range albumTracks {
+			// compilations and "appears on" releases contain other artists' tracks too
+			if !slices.ContainsFunc(t.Artists, func(a SpotifyArtist) bool { return a.ID == payload.SpotifyArtistID }) {
+				continue
+			}
+			tracks = append(tracks, t)
+		}
+	}
+
+	// Recordings already in the library under another Spotify id (e.g. the
+	// single was queued before the album) count as duplicates as well
+	isrcs := []any{}
+	for _, t := range tracks {
+		if isrc := strings.ToUpper(t.ExternalIDs.ISRC); isrc != "" {
+			isrcs = append(isrcs, isrc)
+		}
+	}
+	storedISRCs := map[string]string{} // isrc -> spotify id
+	if len(isrcs) > 0 {
+		existingTracks, err := app.FindAllRecords("tracks", dbx.In("isrc", isrcs...))
+		if err != nil {
+			return nil, err
+		}
+		for _, track := range existingTracks {
+			storedISRCs[strings.ToUpper(track.GetString("isrc"))] = track.GetString("spotify_track_id")
+		}
+	}
+
+	seenISRCs := map[string]struct{}{}
+	unique := []*SpotifyTrack{}
+	for _, t := range tracks {
+		if isrc := strings.ToUpper(t.ExternalIDs.ISRC); isrc != "" {
+			if _, ok := seenISRCs[isrc]; ok {
+				result.Duplicates++
+				continue
+			}
+			seenISRCs[isrc] = struct{}{}
+
+			if spotifyID, ok := storedISRCs[isrc]; ok && spotifyID != t.ID {
+				result.Duplicates++
+				continue
+			}
+		}
+		unique = append(unique, t)
+	}
+	result.Total = len(unique)
+
+	// The album walk already returned the full tracks, no need to fetch them
+	// again through QueueTrack
+	results, err := createTrackRecords(app, unique, priority)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range results {
+		if r.Status == QueueCreated {
+			result.New++
+			continue
+		}
+
+		// failed/cancelled ones are retried, like QueueTrack does
+		existing, err := app.FindRecordById("tracks", r.TrackID)
+		if err == nil {
+			_, r.Status, err = requeueExistingTrack(app, existing, priority)
+		}
+		if err != nil {
+			log.Printf("Failed to queue track %s of artist %s: %v", r.SpotifyTrackID, payload.SpotifyArtistID, err)
+			result.Failed++
+			continue
+		}
+
+		if r.Status == QueueRetried {
+			result.Retried++
+		} else {
+			result.AlreadyPresent++
+		}
+	}
+
+	return result, nil
+}
+
+// fetchSpotifyArtistAlbumIDs pages through the artist's releases of the given groups
+func fetchSpotifyArtistAlbumIDs(ctx context.Context, artistID string, groups []string) ([]string, error) {
+	query := url.Values{}
+	query.Set("include_groups", strings.Join(groups, ","))
+	query.Set("limit", "50")
+	next := "/artists/" + url.PathEscape(artistID) + "/albums?" + query.Encode()
+
+	ids := []string{}
+	for next != "" {
+		var page struct {
+			Items []struct {
+				ID string `json:"id"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		if err := Spotify().get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+
+		next = page.Next
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// The id was malformed or Spotify doesn't know it
+const QueueInvalid QueueStatus = "invalid"
+
+// Max number of ids accepted by a single batch request
+const MaxBatchSize = 1000
+
+// How long a batch may wait on Spotify (a full batch needs 20 multi-get calls)
+const batchMetadataTimeout = 60 * time.Second
+
+var spotifyIDRegex = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+type BatchQueueRequest struct {
+	SpotifyTrackIDs []string `json:"spotify_track_ids"`
+	// "play_now", "normal" (default) or "prefetch"
+	Priority string `json:"priority"`
+}
+
+// BatchQueueResult is the outcome for a single requested id
+type BatchQueueResult struct {
+	SpotifyTrackID string      `json:"spotify_track_id"`
+	Status         QueueStatus `json:"status"`
+	TrackID        string      `json:"track_id,omitempty"`
+}
+
+// QueueTracks queues many tracks at once. Metadata of the new tracks is
+// fetched with Spotify's multi-get endpoint and all records are created in a
+// single transaction. Results are returned in the requested order (duplicated
+// ids are reported once).
+func QueueTracks(ctx context.Context, app core.App, payload BatchQueueRequest) ([]BatchQueueResult, error) {
+	if len(payload.SpotifyTrackIDs) == 0 {
+		return nil, fmt.Errorf("%w: spotify_track_ids is required", ErrInvalidRequest)
+	}
+	if len(payload.SpotifyTrackIDs) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: at most %d spotify_track_ids are allowed", ErrInvalidRequest, MaxBatchSize)
+	}
+
+	priority, err := ParsePriority(payload.Priority)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	// Dedupe and validate the ids
+	results := []BatchQueueResult{}
+	resultIndex := map[string]int{}
+	validIDs := []any{}
+	for _, id := range payload.SpotifyTrackIDs {
+		if _, ok := resultIndex[id]; ok {
+			continue
+		}
+		resultIndex[id] = len(results)
+		results = append(results, BatchQueueResult{SpotifyTrackID: id, Status: QueueInvalid})
+
+		if spotifyIDRegex.MatchString(id) {
+			validIDs = append(validIDs, id)
+		}
+	}
+	if len(validIDs) == 0 {
+		return results, nil
+	}
+
+	// Find the already present tracks
+	existingTracks, err := app.FindAllRecords("tracks", dbx.In("spotify_track_id", validIDs...))
+	if err != nil {
+		return nil, err
+	}
+
+	existingByID := make(map[string]*core.Record, len(existingTracks))
+	for _, track := range existingTracks {
+		existingByID[track.GetString("spotify_track_id")] = track
+	}
+
+	// Fetch the metadata of the new ones
+	missingIDs := []string{}
+	for _, id := range validIDs {
+		if _, ok := existingByID[id.(string)]; !ok {
+			missingIDs = append(missingIDs, id.(string))
+		}
+	}
+
+	spotifyTracks := map[string]*SpotifyTrack{}
+	if len(missingIDs) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, batchMetadataTimeout)
+		defer cancel()
+
+		spotifyTracks, err = fetchSpotifyTracks(ctx, missingIDs)
+		if err != nil {
+			return nil, classifySpotifyError(err)
+		}
+	}
+
+	// Create/requeue everything in one go
+	err = app.RunInTransaction(func(txApp core.App) error {
+		for id, track := range existingByID {
+			record, status, err := requeueExistingTrack(txApp, track, priority)
+			if err != nil {
+				return err
+			}
+			results[resultIndex[id]].Status = status
+			results[resultIndex[id]].TrackID = record.Id
+		}
+
+		for id, spotifyTrack := range spotifyTracks {
+			// make sure we only store what was asked for
+			if _, ok := resultIndex[id]; !ok {
+				continue
+			}
+
+			record, err := saveTrackRecord(txApp, spotifyTrack.Metadata(), priority)
+			if err != nil {
+				return fmt.Errorf("track save error: %w", err)
+			}
+			results[resultIndex[id]].Status = QueueCreated
+			results[resultIndex[id]].TrackID = record.Id
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Wake the worker pool
+	NotifyQueue()
+
+	return results, nil
+}
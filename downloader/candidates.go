@@ -0,0 +1,240 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// How many search results are scored
+const candidateSearchSize = 10
+
+// Candidates scoring below this are never downloaded
+const minCandidateScore = 40
+
+// Candidates without a duration (e.g. Bandcamp search results) can't be
+// rejected for a wrong one, so they need a better title/artist match
+const minCandidateScoreWithoutDuration = 70
+
+// How many of the best candidates without a duration are probed for it
+const durationProbeLimit = 3
+
+// Candidate is a single search result of an audio source
+type Candidate struct {
+	Source          string  `json:"source"`
+	ID              string  `json:"id"`
+	URL             string  `json:"url"`
+	Title           string  `json:"title"`
+	Channel         string  `json:"channel"`
+	ChannelVerified bool    `json:"channel_is_verified"`
+	Duration        float64 `json:"duration"`
+	Score           float64 `json:"score"`
+}
+
+// ytdlpSearch lists the search results of a yt-dlp search target without downloading anything
+func ytdlpSearch(ctx context.Context, target string) ([]Candidate, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "yt-dlp",
+		"--dump-json",
+		"--flat-playlist",
+		"--playlist-end", strconv.Itoa(candidateSearchSize),
+		target,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("yt-dlp search failed: %w", err)
+	}
+
+	// one JSON object per line
+	candidates := []Candidate{}
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Candidate
+			Uploader string `json:"uploader"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+
+		c := entry.Candidate
+		if c.Channel == "" {
+			c.Channel = entry.Uploader
+		}
+		if c.URL == "" && c.ID != "" {
+			c.URL = "https://www.youtube.com/watch?v=" + c.ID
+		}
+		if c.URL != "" {
+			candidates = append(candidates, c)
+		}
+	}
+
+	return candidates, scanner.Err()
+}
+
+// rankCandidates scores the candidates and sorts them best first
+func rankCandidates(cfg MatchConfig, track *core.Record, candidates []Candidate) []Candidate {
+	for i := range candidates {
+		candidates[i].Score = scoreCandidate(cfg, track, candidates[i])
+	}
+
+	sortCandidates(candidates)
+
+	return candidates
+}
+
+// probeMissingDurations fetches the duration of the best candidates the
+// search didn't return one for, so they are scored (and possibly rejected) by
+// it too. Returns the candidates ranked again.
+func probeMissingDurations(ctx context.Context, cfg MatchConfig, track *core.Record, candidates []Candidate) []Candidate {
+	if track.GetInt("duration") <= 0 {
+		return candidates
+	}
+
+	probed := 0
+	for i := range candidates {
+		if probed == durationProbeLimit || candidates[i].Score < minCandidateScore {
+			break
+		}
+		if candidates[i].Duration > 0 {
+			continue
+		}
+
+		probed++
+		c, err := probeCandidate(ctx, candidates[i].Source, candidates[i].URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Probing the duration of %s failed: %v", candidates[i].URL, err)
+			continue
+		}
+		candidates[i].Duration = c.Duration
+	}
+
+	if probed == 0 {
+		return candidates
+	}
+
+	return rankCandidates(cfg, track, candidates)
+}
+
+// acceptableCandidate reports whether the (scored) candidate is good enough to
+// be downloaded
+func acceptableCandidate(track *core.Record, c Candidate) bool {
+	if c.Duration <= 0 && track.GetInt("duration") > 0 {
+		return c.Score >= minCandidateScoreWithoutDuration
+	}
+	return c.Score >= minCandidateScore
+}
+
+// sortCandidates sorts already scored candidates best first
+func sortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+}
+
+// scoreCandidate rates how likely the candidate is the original recording of
+// the track (roughly 0-100, negative for obvious mismatches)
+func scoreCandidate(cfg MatchConfig, track *core.Record, c Candidate) float64 {
+	name := strings.ToLower(track.GetString("name"))
+	title := normalizeForMatch(c.Title)
+	channel := normalizeForMatch(c.Channel)
+
+	// Title similarity (up to 40)
+	score := 40 * tokenOverlap(normalizeForMatch(cleanTrackName(name)), title)
+
+	// Artist in the title or channel (up to 20)
+	artist := track.GetString("artist")
+	if i := strings.Index(artist, ", "); i != -1 {
+		artist = artist[:i] // the main artist is enough
+	}
+	score += 20 * tokenOverlap(normalizeForMatch(artist), title+" "+channel)
+
+	// Auto generated "Artist - Topic" channels carry the official audio (15), verified channels (10)
+	if strings.HasSuffix(strings.TrimSpace(c.Channel), "- Topic") {
+		score += 15
+	} else if c.ChannelVerified {
+		score += 10
+	}
+
+	// Duration delta (up to 25), candidates outside the tolerance are rejected
+	if desired := float64(track.GetInt("duration")) / 1000; desired > 0 && c.Duration > 0 {
+		delta := math.Abs(c.Duration - desired)
+		allowed := cfg.ToleranceFor(c.Source).Allowed(desired, c.Duration)
+		switch {
+		case delta > allowed:
+			return -100
+		case allowed > 0:
+			score += 25 * (1 - delta/allowed)
+		default:
+			// no tolerance at all, only exact matches get here
+			score += 25
+		}
+	}
+
+	// Keywords (10 for every wanted one, -30 for every alternative version marker)
+	paddedTitle := " " + title + " "
+	for _, keyword := range cfg.IncludeKeywords {
+		if strings.Contains(paddedTitle, " "+normalizeForMatch(keyword)+" ") {
+			score += 10
+		}
+	}
+	normalizedName := " " + normalizeForMatch(name) + " "
+	for _, keyword := range cfg.ExcludeKeywords {
+		keyword = " " + normalizeForMatch(keyword) + " "
+		if strings.Contains(paddedTitle, keyword) && !strings.Contains(normalizedName, keyword) {
+			score -= 30
+		}
+	}
+
+	return score
+}
+
+// normalizeForMatch lowercases the string and replaces punctuation with spaces
+func normalizeForMatch(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// tokenOverlap returns the share of want's words that appear in have (0-1)
+func tokenOverlap(want, have string) float64 {
+	wantTokens := strings.Fields(want)
+	if len(wantTokens) == 0 {
+		return 0
+	}
+
+	haveTokens := map[string]struct{}{}
+	for _, t := range strings.Fields(have) {
+		haveTokens[t] = struct{}{}
+	}
+
+	found := 0
+	for _, t := range wantTokens {
+		if _, ok := haveTokens[t]; ok {
+			found++
+		}
+	}
+
+	return float64(found) / float64(len(wantTokens))
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newTestTrack builds an unsaved tracks record with the fields used for matching
+func newTestTrack(name, artist string, durationMs int) *core.Record {
+	track := core.NewRecord(core.NewBaseCollection("tracks"))
+	track.Set("name", name)
+	track.Set("artist", artist)
+	track.Set("duration", durationMs)
+	return track
+}
+
+func TestNormalizeForMatch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Blinding Lights", "blinding lights"},
+		{"The Weeknd - Blinding Lights (Official Audio)", "the weeknd blinding lights official audio"},
+		{"  AC/DC  ", "ac dc"},
+		{"Beyoncé – Halo", "beyoncé halo"},
+		{"99 Luftballons", "99 luftballons"},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeForMatch(tt.input); got != tt.want {
+			t.Errorf("normalizeForMatch(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTokenOverlap(t *testing.T) {
+	tests := []struct {
+		want string
+		have string
+		out  float64
+	}{
+		{"blinding lights", "the weeknd blinding lights", 1},
+		{"blinding lights", "blinding", 0.5},
+		{"blinding lights", "save your tears", 0},
+		{"a b c d", "d c", 0.5},
+		{"", "anything", 0},
+		{"blinding", "", 0},
+	}
+
+	for _, tt := range tests {
+		if got := tokenOverlap(tt.want, tt.have); got != tt.out {
+			t.Errorf("tokenOverlap(%q, %q) = %v, want %v", tt.want, tt.have, got, tt.out)
+		}
+	}
+}
+
+func TestScoreCandidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		trackName string
+		candidate Candidate
+		want      float64
+	}{
+		{
+			"official audio on a topic channel",
+			"Blinding Lights",
+			Candidate{Title: "The Weeknd - Blinding Lights (Official Audio)", Channel: "The Weeknd - Topic", Duration: 200},
+			40 + 20 + 15 + 25 + 10,
+		},
+		{
+			"verified channel",
+			"Blinding Lights",
+			Candidate{Title: "Blinding Lights", Channel: "The Weeknd", ChannelVerified: true, Duration: 200},
+			40 + 20 + 10 + 25,
+		},
+		{
+			"live version off by 30s",
+			"Blinding Lights",
+			Candidate{Title: "The Weeknd - Blinding Lights (Live)", Channel: "Some Uploader", Duration: 230},
+			40 + 20 + 12.5 - 30,
+		},
+		{
+			"shorter by 15s",
+			"Blinding Lights",
+			Candidate{Title: "Blinding Lights", Channel: "The Weeknd - Topic", Duration: 185},
+			40 + 20 + 15 + 12.5,
+		},
+		{
+			"too short",
+			"Blinding Lights",
+			Candidate{Title: "Blinding Lights", Channel: "The Weeknd - Topic", Duration: 165},
+			-100,
+		},
+		{
+			"outside the duration tolerance",
+			"Blinding Lights",
+			Candidate{Title: "The Weeknd - Blinding Lights", Channel: "The Weeknd - Topic", Duration: 300},
+			-100,
+		},
+		{
+			"unknown duration isn't scored",
+			"Blinding Lights",
+			Candidate{Title: "Blinding Lights", Channel: "The Weeknd"},
+			40 + 20,
+		},
+		{
+			"exclude keyword in the track name isn't a penalty",
+			"Blinding Lights (Remix)",
+			Candidate{Title: "Blinding Lights Remix", Channel: "The Weeknd"},
+			40 + 20,
+		},
+		{
+			"exclude keyword",
+			"Blinding Lights",
+			Candidate{Title: "Blinding Lights Remix", Channel: "The Weeknd"},
+			40 + 20 - 30,
+		},
+		{
+			"only part of the title",
+			"Blinding Lights",
+			Candidate{Title: "Lights", Channel: "Someone Else"},
+			20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := newTestTrack(tt.trackName, "The Weeknd", 200000)
+			if got := scoreCandidate(defaultMatchConfig, track, tt.candidate); got != tt.want {
+				t.Errorf("scoreCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreCandidateMainArtist(t *testing.T) {
+	track := newTestTrack("Stay", "The Kid LAROI, Justin Bieber", 0)
+
+	// only the main artist is looked for
+	got := scoreCandidate(defaultMatchConfig, track, Candidate{Title: "Stay", Channel: "The Kid LAROI"})
+	if got != 40+20 {
+		t.Fatalf("scoreCandidate() = %v, want %v", got, 40+20)
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	track := newTestTrack("Blinding Lights", "The Weeknd", 200000)
+
+	ranked := rankCandidates(defaultMatchConfig, track, []Candidate{
+		{ID: "cover", Title: "Blinding Lights (Cover)", Channel: "Someone", Duration: 200},
+		{ID: "long", Title: "Blinding Lights 1 hour", Channel: "Someone", Duration: 3600},
+		{ID: "topic", Title: "Blinding Lights", Channel: "The Weeknd - Topic", Duration: 201},
+		{ID: "video", Title: "The Weeknd - Blinding Lights (Official Video)", Channel: "The Weeknd", Duration: 215},
+	})
+
+	want := []string{"topic", "video", "cover", "long"}
+	for i, id := range want {
+		if ranked[i].ID != id {
+			t.Fatalf("ranked[%d] = %s (%v), want %s", i, ranked[i].ID, ranked[i].Score, id)
+		}
+	}
+}
+
+func TestAcceptableCandidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		durationMs int
+		candidate  Candidate
+		want       bool
+	}{
+		{"good enough", 200000, Candidate{Score: 40, Duration: 200}, true},
+		{"too low", 200000, Candidate{Score: 39.9, Duration: 200}, false},
+		{"rejected by duration", 200000, Candidate{Score: -100, Duration: 600}, false},
+		{"no candidate duration needs a better match", 200000, Candidate{Score: 60}, false},
+		{"no candidate duration but a good match", 200000, Candidate{Score: 70}, true},
+		{"no track duration", 0, Candidate{Score: 40}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := newTestTrack("Blinding Lights", "The Weeknd", tt.durationMs)
+			if got := acceptableCandidate(track, tt.candidate); got != tt.want {
+				t.Errorf("acceptableCandidate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// How long a FillSpotifyArtistImages run may take
+const artistImagesTimeout = 2 * time.Minute
+
+// Max artists looked up per FillSpotifyArtistImages run
+const artistImagesPerRun = 500
+
+var fillArtistImagesMu sync.Mutex
+
+// linkCatalog points the track record to its artist and album records,
+// creating the ones that don't exist yet (and updating the changed ones)
+func linkCatalog(app core.App, record *core.Record, t *TrackMetadata) error {
+	artists := []string{}
+	for _, a := range t.Artists {
+		artist, err := findOrCreateArtist(app, t.Provider, a)
+		if err != nil {
+			return err
+		}
+		if artist != nil {
+			artists = append(artists, artist.Id)
+		}
+	}
+	record.Set("artists", artists)
+
+	album, err := findOrCreateAlbum(app, t.Provider, t.Album)
+	if err != nil {
+		return err
+	}
+	if album != nil {
+		record.Set("album_record", album.Id)
+	}
+
+	return nil
+}
+
+func findOrCreateArtist(app core.App, provider string, a ArtistMetadata) (*core.Record, error) {
+	if a.ID == "" {
+		return nil, nil
+	}
+
+	return findOrCreateCatalogRecord(app, "artists", provider, a.ID, func(artist *core.Record) {
+		artist.Set("name", a.Name)
+	})
+}
+
+func findOrCreateAlbum(app core.App, provider string, a AlbumMetadata) (*core.Record, error) {
+	if a.ID == "" {
+		return nil, nil
+	}
+
+	artists := []string{}
+	for _, albumArtist := range a.Artists {
+		artist, err := findOrCreateArtist(app, provider, albumArtist)
+		if err != nil {
+			return nil, err
+		}
+		if artist != nil {
+			artists = append(artists, artist.Id)
+		}
+	}
+
+	return findOrCreateCatalogRecord(app, "albums", provider, a.ID, func(album *core.Record) {
+		album.Set("name", a.Name)
+		album.Set("release_date", a.ReleaseDate)
+		album.Set("album_type", a.Type)
+		album.Set("total_tracks", a.TotalTracks)
+		if a.CoverURL != "" {
+			album.Set("cover_url", a.CoverURL)
+		}
+		album.Set("artists", artists)
+	})
+}
+
+// findOrCreateCatalogRecord returns the artists/albums record with the provider id.
+// Missing records are created with the fields set by fill - existing ones are
+// saved only if fill changed any of their fields.
+func findOrCreateCatalogRecord(app core.App, collection, provider, id string, fill func(*core.Record)) (*core.Record, error) {
+	params := dbx.Params{"provider": provider, "id": id}
+	filter := "provider={:provider} && provider_id={:id}"
+
+	record, err := app.FindFirstRecordByFilter(collection, filter, params)
+	if err == nil {
+		original := record.Clone()
+		fill(record)
+		if !reflect.DeepEqual(original.FieldsData(), record.FieldsData()) {
+			if err := app.Save(record); err != nil {
+				return nil, err
+			}
+		}
+		return record, nil
+	}
+
+	col, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	record = core.NewRecord(col)
+	record.Set("provider", provider)
+	record.Set("provider_id", id)
+	fill(record)
+
+	if err := app.Save(record); err != nil {
+		// created in the meantime by a concurrent request (unique index)
+		if existing, findErr := app.FindFirstRecordByFilter(collection, filter, params); findErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// FillSpotifyArtistImages looks up the images of the Spotify artists that
+// weren't checked yet (used by the cron, so no Spotify request ever runs while
+// a track is being saved). Checked artists get an image_checked_at, so the
+// ones without an image aren't looked up again. Returns how many got an image.
+func FillSpotifyArtistImages(ctx context.Context, app core.App) (int, error) {
+	// a slow run must not overlap with the next one
+	if !fillArtistImagesMu.TryLock() {
+		return 0, nil
+	}
+	defer fillArtistImagesMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, artistImagesTimeout)
+	defer cancel()
+
+	artists, err := app.FindRecordsByFilter(
+		"artists",
+		"provider={:provider} && image_checked_at=''",
+		"created",
+		artistImagesPerRun,
+		0,
+		dbx.Params{"provider": ProviderSpotify},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	filled := 0
+	for start := 0; start < len(artists); start += spotifyTracksBatchSize {
+		batch := artists[start:min(start+spotifyTracksBatchSize, len(artists))]
+
+		ids := make([]string, 0, len(batch))
+		for _, artist := range batch {
+			ids = append(ids, artist.GetString("provider_id"))
+		}
+
+		images, err := fetchSpotifyArtistImages(ctx, ids)
+		if err != nil {
+			return filled, err
+		}
+
+		now := types.NowDateTime()
+		for _, artist := range batch {
+			if imageURL := images[artist.GetString("provider_id")]; imageURL != "" {
+				artist.Set("image_url", imageURL)
+				filled++
+			}
+			artist.Set("image_checked_at", now)
+			if err := app.Save(artist); err != nil {
+				return filled, err
+			}
+		}
+	}
+
+	return filled, nil
+}
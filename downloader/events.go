@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// How many past events are kept around for clients resuming with Last-Event-ID
+const eventHistorySize = 1000
+
+// Buffered events per subscriber - slow clients drop events instead of blocking the broker
+const subscriberBufferSize = 64
+
+// TrackEvent is a status/progress transition of a single track
+type TrackEvent struct {
+	// SSE id, "<epoch>-<seq>" (see EventBroker)
+	ID             string `json:"-"`
+	seq            uint64
+	TrackID        string `json:"track_id"`
+	SpotifyTrackID string `json:"spotify_track_id"`
+	Provider       string `json:"provider"`
+	ProviderID     string `json:"provider_id"`
+	Status         string `json:"download_status"`
+	Phase          string `json:"download_phase"`
+	Progress       int    `json:"download_progress"`
+}
+
+func newTrackEvent(track *core.Record) TrackEvent {
+	return TrackEvent{
+		TrackID:        track.Id,
+		SpotifyTrackID: track.GetString("spotify_track_id"),
+		Provider:       track.GetString("provider"),
+		ProviderID:     track.GetString("provider_id"),
+		Status:         track.GetString("download_status"),
+		Phase:          track.GetString("download_phase"),
+		Progress:       track.GetInt("download_progress"),
+	}
+}
+
+type subscriber struct {
+	ids map[string]struct{}
+	ch  chan TrackEvent
+}
+
+// wants matches the event against the subscribed track refs (see FindTrack)
+func (s *subscriber) wants(e TrackEvent) bool {
+	refs := []string{e.TrackID, e.Provider + ":" + e.ProviderID}
+	if e.SpotifyTrackID != "" {
+		refs = append(refs, e.SpotifyTrackID)
+	}
+
+	for _, ref := range refs {
+		if _, ok := s.ids[ref]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EventBroker fans out track events to the SSE subscribers.
+//
+// The event sequence restarts with every run, so the event ids are prefixed
+// with the start time of the run (the epoch) - ids of a previous run are never
+// mistaken for ones of the current run.
+type EventBroker struct {
+	mu          sync.Mutex
+	epoch       string
+	lastID      uint64
+	history     []TrackEvent
+	subscribers map[*subscriber]struct{}
+
+	// last published state of the in-progress tracks, keyed by track record id
+	lastState map[string]TrackEvent
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		epoch:       strconv.FormatInt(time.Now().UnixMilli(), 10),
+		subscribers: make(map[*subscriber]struct{}),
+		lastState:   make(map[string]TrackEvent),
+	}
+}
+
+// PublishTrack publishes an event for the track if its status, phase or
+// progress changed (other field updates, like the lease heartbeat, are ignored)
+func (b *EventBroker) PublishTrack(track *core.Record) {
+	event := newTrackEvent(track)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if last, ok := b.lastState[event.TrackID]; ok && last == event {
+		return
+	}
+
+	switch event.Status {
+	case "queued", "downloading":
+		b.lastState[event.TrackID] = event
+	default:
+		// final state, no need to remember it anymore
+		delete(b.lastState, event.TrackID)
+	}
+
+	b.lastID++
+	event.seq = b.lastID
+	event.ID = b.eventID(b.lastID)
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for s := range b.subscribers {
+		if !s.wants(event) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+		}
+	}
+}
+
+// ForgetTrack drops the remembered state of a (deleted) track
+func (b *EventBroker) ForgetTrack(track *core.Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.lastState, track.Id)
+}
+
+// Subscribe registers a subscriber for the given tracks - Spotify track ids,
+// track record ids or "<provider>:<provider_id>" refs (see FindTrack).
+//
+// If lastEventID is set, the missed events are returned as backlog. When the
+// missed events are not in the history anymore (or the id is from a previous
+// run) resumed is false and the caller should send a fresh snapshot instead.
+func (b *EventBroker) Subscribe(trackRefs []string, lastEventID string) (
+	events <-chan TrackEvent,
+	backlog []TrackEvent,
+	resumed bool,
+	unsubscribe func(),
+) {
+	s := &subscriber{
+		ids: make(map[string]struct{}, len(trackRefs)),
+		ch:  make(chan TrackEvent, subscriberBufferSize),
+	}
+	for _, ref := range trackRefs {
+		s.ids[ref] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastSeq, ok := b.parseEventID(lastEventID); ok && lastSeq <= b.lastID {
+		oldest := b.lastID - uint64(len(b.history)) + 1
+		if lastSeq+1 >= oldest {
+			resumed = true
+			for _, e := range b.history {
+				if e.seq > lastSeq && s.wants(e) {
+					backlog = append(backlog, e)
+				}
+			}
+		}
+	}
+
+	b.subscribers[s] = struct{}{}
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		delete(b.subscribers, s)
+		b.mu.Unlock()
+	}
+
+	return s.ch, backlog, resumed, unsubscribe
+}
+
+// LastEventID returns the id of the latest published event
+func (b *EventBroker) LastEventID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.eventID(b.lastID)
+}
+
+func (b *EventBroker) eventID(seq uint64) string {
+	return b.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseEventID returns the sequence number of an event id of the current run
+func (b *EventBroker) parseEventID(id string) (uint64, bool) {
+	epoch, seq, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// Snapshot returns the current state of the requested tracks as events
+func Snapshot(app core.App, trackRefs []string, eventID string) []TrackEvent {
+	events := []TrackEvent{}
+	for _, ref := range trackRefs {
+		track, err := FindTrack(app, ref)
+		if err != nil {
+			// not queued (yet) - nothing to report
+			continue
+		}
+
+		event := newTrackEvent(track)
+		event.ID = eventID
+		events = append(events, event)
+	}
+
+	return events
+}
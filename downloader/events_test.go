@@ -0,0 +1,81 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newTestTrackState builds an unsaved tracks record in the given download status
+func newTestTrackState(id, status string, progress int) *core.Record {
+	track := core.NewRecord(core.NewBaseCollection("tracks"))
+	track.Id = id
+	track.Set("download_status", status)
+	track.Set("download_progress", progress)
+	return track
+}
+
+func TestEventBrokerSubscribeResume(t *testing.T) {
+	previousRun := NewEventBroker()
+	previousRun.epoch = "1000"
+	for i := 1; i <= 3; i++ {
+		previousRun.PublishTrack(newTestTrackState("track1", "downloading", i))
+	}
+	previousLastID := previousRun.LastEventID()
+
+	b := NewEventBroker()
+	b.epoch = "2000"
+	for i := 1; i <= 5; i++ {
+		b.PublishTrack(newTestTrackState("track1", "downloading", i*10))
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		wantResumed bool
+		wantBacklog int
+	}{
+		{"no id", "", false, 0},
+		{"current run", "2000-3", true, 2},
+		{"up to date", "2000-5", true, 0},
+		{"from the future", "2000-6", false, 0},
+		// the previous run's seq 3 is below the current lastID, but must not resume
+		{"previous run", previousLastID, false, 0},
+		{"bare number", "3", false, 0},
+		{"garbage", "2000-abc", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, backlog, resumed, unsubscribe := b.Subscribe([]string{"track1"}, tt.lastEventID)
+			defer unsubscribe()
+
+			if resumed != tt.wantResumed || len(backlog) != tt.wantBacklog {
+				t.Fatalf("Subscribe(%q) = %d events, resumed %v, want %d events, resumed %v",
+					tt.lastEventID, len(backlog), resumed, tt.wantBacklog, tt.wantResumed)
+			}
+		})
+	}
+}
+
+func TestEventBrokerHistoryOverflow(t *testing.T) {
+	b := NewEventBroker()
+	for i := 0; i <= eventHistorySize+1; i++ {
+		b.PublishTrack(newTestTrackState("track1", "downloading", i))
+	}
+
+	// event 2 (the one after the last seen) was dropped from the history
+	if _, _, resumed, unsubscribe := b.Subscribe([]string{"track1"}, b.eventID(1)); resumed {
+		unsubscribe()
+		t.Fatal("resumed although events were missed")
+	}
+}
+
+func TestEventBrokerEpochs(t *testing.T) {
+	b := NewEventBroker()
+	b.PublishTrack(newTestTrackState("track1", "queued", 0))
+
+	if id := b.LastEventID(); id != b.epoch+"-1" {
+		t.Fatalf("LastEventID() = %q, want %q", id, b.epoch+"-1")
+	}
+}
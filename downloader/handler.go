@@ -1,222 +1,409 @@
 package downloader
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bogem/id3v2"
 	"github.com/google/uuid"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/filesystem"
 )
 
 type DownloadRequest struct {
 	SpotifyTrackID string `json:"spotify_track_id"`
+	// Or the track id at another metadata provider ("spotify" by default)
+	Provider   string `json:"provider"`
+	ProviderID string `json:"provider_id"`
+	// Or an ISRC, looked up at the provider
+	ISRC string `json:"isrc"`
+	// "play_now", "normal" (default) or "prefetch"
+	Priority string `json:"priority"`
 }
 
 // ---- SPOTIFY API MODELS ----
+type SpotifyArtist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type SpotifyImage struct {
+	URL string `json:"url"`
+}
+
+type SpotifyAlbum struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	ReleaseDate string          `json:"release_date"`
+	AlbumType   string          `json:"album_type"`
+	TotalTracks int             `json:"total_tracks"`
+	Artists     []SpotifyArtist `json:"artists"`
+	Images      []SpotifyImage  `json:"images"`
+}
+
 type SpotifyTrack struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	DurationMs  int    `json:"duration_ms"`
 	TrackNumber int    `json:"track_number"`
 	DiscNumber  int    `json:"disc_number"`
+	Explicit    bool   `json:"explicit"`
+	Popularity  int    `json:"popularity"`
+
+	Artists []SpotifyArtist `json:"artists"`
+	Album   SpotifyAlbum    `json:"album"`
+
+	ExternalIDs struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+}
+
+// QueueStatus tells what QueueTrack did with the requested track
+type QueueStatus string
+
+const (
+	QueueCreated  QueueStatus = "created"
+	QueueExisting QueueStatus = "already_present"
+	QueueRetried  QueueStatus = "retried"
+)
 
-	Artists []struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
-	} `json:"artists"`
-
-	Album struct {
-		ID          string `json:"id"`
-		Name        string `json:"name"`
-		ReleaseDate string `json:"release_date"`
-		Artists []struct {
-			ID   string `json:"id"`
-		} `json:"artists"`
-		Images      []struct {
-			URL string `json:"url"`
-		} `json:"images"`
-	} `json:"album"`
+var (
+	// The request itself is wrong (missing id, unknown priority...)
+	ErrInvalidRequest = errors.New("invalid request")
+	// Spotify doesn't know the requested track/album/...
+	ErrSpotifyNotFound = fmt.Errorf("%w on spotify", ErrMetadataNotFound)
+	// The metadata provider couldn't be reached or failed to answer
+	ErrUpstream = errors.New("metadata request failed")
+)
+
+// Identity describes the requested track (for logging)
+func (r DownloadRequest) Identity() string {
+	switch {
+	case r.SpotifyTrackID != "":
+		return ProviderSpotify + ":" + r.SpotifyTrackID
+	case r.ProviderID != "":
+		return r.Provider + ":" + r.ProviderID
+	default:
+		return "isrc:" + r.ISRC
+	}
 }
 
-func QueueTrack(app core.App, payload DownloadRequest) (*core.Record, error) {
-	if payload.SpotifyTrackID == "" {
-		return nil, errors.New("spotify_track_id is required")
+// How long QueueTrack may wait on Spotify
+const metadataTimeout = 10 * time.Second
+
+// QueueTrack creates a queued track record for the requested track - identified by
+// its Spotify id, its id at another metadata provider or its ISRC.
+// Already present tracks are returned as they are, failed/cancelled ones are retried.
+func QueueTrack(ctx context.Context, app core.App, payload DownloadRequest) (*core.Record, QueueStatus, error) {
+	providerName, id := payload.Provider, payload.ProviderID
+	if payload.SpotifyTrackID != "" {
+		providerName, id = ProviderSpotify, payload.SpotifyTrackID
+	}
+	if id == "" && payload.ISRC == "" {
+		return nil, "", fmt.Errorf("%w: spotify_track_id, provider_id or isrc is required", ErrInvalidRequest)
+	}
+
+	provider, err := GetMetadataProvider(providerName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	priority, err := ParsePriority(payload.Priority)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 	}
 
 	// Check if track already exists - so we dont create duplicate requests
-	existingTrack, err := app.FindFirstRecordByData("tracks", "spotify_track_id", payload.SpotifyTrackID)
-	if err == nil {
-		// Check if track failed the download
-		if existingTrack.GetString("download_status") == "failed" {
-			// Retry the download process
-			existingTrack.Set("download_status", "queued");
-			app.Save(existingTrack)
-			return existingTrack, nil;
+	if id != "" {
+		if existingTrack, err := findTrackByProviderID(app, provider.Name(), id); err == nil {
+			return requeueExistingTrack(app, existingTrack, priority)
 		}
-
-		// Track was/is already succesfully download-ed/ing
-		return nil, errors.New("track with provided spotify_track_id was/is already download-ed/ing")
+	} else if existingTrack, err := app.FindFirstRecordByData("tracks", "isrc", payload.ISRC); err == nil {
+		// the same recording was already queued (possibly through another provider)
+		return requeueExistingTrack(app, existingTrack, priority)
 	}
 
-	// Get track metadata from Spotify
-	spotifyTrack, err := fetchSpotifyMetadata(payload.SpotifyTrackID)
+	// Get track metadata from the provider
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	var meta *TrackMetadata
+	if id != "" {
+		meta, err = provider.LookupID(ctx, id)
+	} else {
+		meta, err = provider.LookupISRC(ctx, payload.ISRC)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("spotify metadata error: %w", err)
+		return nil, "", err
 	}
 
-	fmt.Printf("Fetched from Spotify: %s - %s\n", spotifyTrack.Name, spotifyTrack.Album.Name)
+	fmt.Printf("Fetched from %s: %s - %s\n", meta.Provider, meta.Name, meta.Album.Name)
+
+	// ISRC lookups only know the track id now
+	if existingTrack, err := findTrackByProviderID(app, meta.Provider, meta.ProviderID); err == nil {
+		return requeueExistingTrack(app, existingTrack, priority)
+	}
 
 	// Create track record
-	track, err := saveTrackRecord(app, spotifyTrack)
+	track, err := saveTrackRecord(app, meta, priority)
 	if err != nil {
-		return nil, fmt.Errorf("track save error: %w", err)
+		// a concurrent request for the same track won the race (unique index)
+		if existingTrack, findErr := findTrackByProviderID(app, meta.Provider, meta.ProviderID); findErr == nil {
+			return existingTrack, QueueExisting, nil
+		}
+		return nil, "", fmt.Errorf("track save error: %w", err)
 	}
 
-	return track, nil
+	// Wake the worker pool
+	NotifyQueue()
+
+	return track, QueueCreated, nil
+}
+
+func findTrackByProviderID(app core.App, provider, id string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter("tracks", "provider={:provider} && provider_id={:id}", dbx.Params{
+		"provider": provider,
+		"id":       id,
+	})
+}
+
+// FindTrack looks up a track by any of its TrackRefs: its Spotify track id, its
+// record id or "<provider>:<provider_id>". Tracks queued through another
+// provider (or by ISRC) have no Spotify id, so they need one of the others.
+func FindTrack(app core.App, ref string) (*core.Record, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	if provider, id, ok := strings.Cut(ref, ":"); ok {
+		return findTrackByProviderID(app, provider, id)
+	}
+
+	if track, err := app.FindFirstRecordByData("tracks", "spotify_track_id", ref); err == nil {
+		return track, nil
+	}
+
+	return app.FindRecordById("tracks", ref)
+}
+
+// TrackRefs returns the references FindTrack resolves to the track
+func TrackRefs(track *core.Record) []string {
+	refs := []string{track.Id}
+	if id := track.GetString("spotify_track_id"); id != "" {
+		refs = append(refs, id)
+	}
+	if provider, id := track.GetString("provider"), track.GetString("provider_id"); provider != "" && id != "" {
+		refs = append(refs, provider+":"+id)
+	}
+	return refs
+}
+
+// requeueExistingTrack handles a repeated request for an already present track
+func requeueExistingTrack(app core.App, existingTrack *core.Record, priority int) (*core.Record, QueueStatus, error) {
+	switch existingTrack.GetString("download_status") {
+	case "failed", "cancelled":
+		// Retry the download process (with a fresh set of attempts)
+		existingTrack.Set("download_status", "queued")
+		existingTrack.Set("attempts", 0)
+		existingTrack.Set("next_attempt_at", "")
+		existingTrack.Set("priority", priority)
+		if err := app.Save(existingTrack); err != nil {
+			return nil, "", fmt.Errorf("track save error: %w", err)
+		}
+		NotifyQueue()
+		return existingTrack, QueueRetried, nil
+	case "queued":
+		// Track is still waiting in the queue - bump it if it was requested with a higher priority
+		if priority > existingTrack.GetInt("priority") {
+			existingTrack.Set("priority", priority)
+			if err := app.Save(existingTrack); err != nil {
+				return nil, "", fmt.Errorf("track save error: %w", err)
+			}
+			NotifyQueue()
+		}
+	}
+
+	// Track was/is already download-ed/ing (or gave up - dead tracks have to be requeued by an admin)
+	return existingTrack, QueueExisting, nil
+}
+
+// classifySpotifyError maps a metadata fetch error to ErrSpotifyNotFound or ErrUpstream
+func classifySpotifyError(err error) error {
+	var spotifyErr *SpotifyError
+	if errors.As(err, &spotifyErr) && (spotifyErr.StatusCode == http.StatusNotFound || spotifyErr.StatusCode == http.StatusBadRequest) {
+		return fmt.Errorf("%w: %w", ErrSpotifyNotFound, err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrUpstream, err)
 }
 
 // ======================================================================
 // MAIN HANDLER ENTRY
 // ======================================================================
-func DownloadTrack(app core.App, track *core.Record) (*core.Record, error) {
-	// Download from youtube
+func DownloadTrack(ctx context.Context, app core.App, track *core.Record) (*core.Record, error) {
+	// Download the audio (YouTube by default, see AudioSources)
 	downloadDir := "./downloads"
 	os.MkdirAll(downloadDir, os.ModePerm)
 
 	fileID := uuid.New().String()
 	tmpFile := filepath.Join(downloadDir, fmt.Sprintf("%s.mp3", fileID))
 
-	cmd := createYTDLPCommand(track, tmpFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// remove the temp file and any partial yt-dlp leftovers (.part, .webm, ...)
+	defer cleanupTempFiles(downloadDir, fileID)
 
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-        // Exit code 101 means "Max downloads reached", which is a SUCCESS for us
-        if exitErr.ExitCode() == 101 {
-            err = nil 
-        }
-    }
+	progress := newProgressReporter(app, track)
+	progress.Report(PhaseSearching, 0)
 
-    // If err is still not nil (meaning it's a real error, like 1 or 255), handle it
-    if err != nil {
-			return nil, fmt.Errorf("yt-dlp failed: %w", err)
-    }
+	best, err := pickSource(ctx, app, track)
+	if err != nil {
+		return nil, err
 	}
+	log.Printf("Downloading %q from %s (%s, score %.1f)", best.Title, best.URL, best.Source, best.Score)
+
+	source, err := GetAudioSource(best.Source)
+	if err != nil {
+		return nil, err
+	}
+	if err := source.Fetch(ctx, best, tmpFile, progress); err != nil {
+		return nil, err
+	}
+
+	// Keep track of where the audio came from (and why), so bad matches can be audited
+	track.Set("source_url", best.URL)
+	track.Set("source_id", best.ID)
+	track.Set("source_title", best.Title)
+	track.Set("source_channel", best.Channel)
+	track.Set("source_duration", best.Duration)
+	track.Set("match_score", best.Score)
 
 	// Apply ID3 tags
+	progress.Report(PhaseTagging, 100)
 	if err := writeID3Tags(track, tmpFile, fileID, downloadDir); err != nil {
 		return nil, err
 	}
 
 	// Update record and save file to R2
+	progress.Report(PhaseUploading, 100)
 	record, err := updateTrackRecord(app, track, tmpFile)
 	if err != nil {
 		return nil, fmt.Errorf("record save error: %w", err)
 	}
 
-	// delete local temp file
-	os.Remove(tmpFile)
-
 	return record, nil
 }
 
-// ======================================================================
-//  SPOTIFY METADATA FETCH
-// ======================================================================
-
-func fetchSpotifyMetadata(trackID string) (*SpotifyTrack, error) {
-	token, err := getSpotifyToken()
-	if err != nil {
-		return nil, err
+// pickSource returns the candidate to download - the pinned override if there
+// is one, otherwise the best scoring search result. The audio sources are
+// searched in their fallback order until one has an acceptable candidate.
+func pickSource(ctx context.Context, app core.App, track *core.Record) (Candidate, error) {
+	if override := track.GetString("source_override"); override != "" {
+		source, sourceURL, err := ParseSourceURL(override)
+		if err != nil {
+			return Candidate{}, Permanent(err)
+		}
+		c, err := probeCandidate(ctx, source, sourceURL)
+		if err != nil {
+			return Candidate{}, err
+		}
+		// only informative, the pinned source is downloaded regardless
+		c.Score = scoreCandidate(CurrentMatchConfig(), track, c)
+		return c, nil
 	}
 
-	req, _ := http.NewRequest("GET", "https://api.spotify.com/v1/tracks/"+trackID, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	cfg := CurrentMatchConfig()
+
+	// Score the search results and download only the best one
+	var searchErr error
+	candidates := []Candidate{}
+	seen := map[string]struct{}{}
+	found := false
+search:
+	for _, source := range AudioSources() {
+		for _, query := range cfg.Queries(track, source.Name()) {
+			results, err := source.Search(ctx, query)
+			if ctx.Err() != nil {
+				return Candidate{}, ctx.Err()
+			}
+			if err != nil {
+				log.Printf("Searching %s for track %s failed: %v", source.Name(), track.Id, err)
+				searchErr = err
+				continue
+			}
+
+			results = probeMissingDurations(ctx, cfg, track, rankCandidates(cfg, track, results))
+			if ctx.Err() != nil {
+				return Candidate{}, ctx.Err()
+			}
+
+			// the queries overlap, keep every result only once
+			for _, c := range results {
+				if _, ok := seen[c.Source+":"+c.ID]; !ok {
+					seen[c.Source+":"+c.ID] = struct{}{}
+					candidates = append(candidates, c)
+				}
+				found = found || acceptableCandidate(track, c)
+			}
+			if found {
+				break search
+			}
+		}
 	}
-	defer resp.Body.Close()
+	sortCandidates(candidates)
 
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("spotify error %d: %s", resp.StatusCode, body)
+	track.Set("candidates", candidates)
+	if err := saveLeasedTrack(app, track, track.GetString("lease_owner")); err != nil {
+		log.Printf("Failed to save candidates for track %s: %v", track.Id, err)
 	}
 
-	var track SpotifyTrack
-	json.NewDecoder(resp.Body).Decode(&track)
-
-	return &track, nil
-}
-
-func getSpotifyToken() (string, error) {
-	clientID := os.Getenv("SPOTIFY_CLIENT_ID")
-	clientSecret := os.Getenv("SPOTIFY_CLIENT_SECRET")
-
-	if clientID == "" || clientSecret == "" {
-		return "", errors.New("missing spotify client id/secret env vars")
+	for _, c := range candidates {
+		if acceptableCandidate(track, c) {
+			return c, nil
+		}
 	}
 
-	auth := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
-
-	reqData := url.Values{}
-	reqData.Set("grant_type", "client_credentials")
-
-	req, _ := http.NewRequest("POST",
-			"https://accounts.spotify.com/api/token",
-			strings.NewReader(reqData.Encode()),
-	)
-	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+	// a source failing (rather than finding nothing) is worth a retry
+	if searchErr != nil {
+		return Candidate{}, searchErr
 	}
-	defer resp.Body.Close()
+	return Candidate{}, Permanent(ErrNoMatchingVideo)
+}
 
-	var data struct {
-		AccessToken string `json:"access_token"`
+func cleanupTempFiles(dir, fileID string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, fileID+"*"))
+	for _, m := range matches {
+		os.Remove(m)
 	}
-	json.NewDecoder(resp.Body).Decode(&data)
-
-	return data.AccessToken, nil
 }
 
 // ======================================================================
 //  YT-DLP COMMAND
 // ======================================================================
 
-func createYTDLPCommand(track *core.Record, tmpFile string) *exec.Cmd {
-	search := fmt.Sprintf("%s %s official audio", track.GetString("artist"), cleanTrackName(track.GetString("name")))
-
-	desired := track.GetInt("duration") / 1000
-	min := desired - 60
-	max := desired + 5
-
-	return exec.Command("yt-dlp",
+// createYTDLPCommand downloads the audio of a single (already picked) video
+func createYTDLPCommand(ctx context.Context, sourceURL string, tmpFile string) *exec.Cmd {
+	return exec.CommandContext(ctx, "yt-dlp",
 		"--extract-audio",
 		"--audio-format", "mp3",
 		"--audio-quality", "0",
 		"--output", tmpFile,
 		"--format", "bestaudio/best",
 		"--no-playlist",
-		"--match-filter", fmt.Sprintf("duration>%d & duration<%d", min, max),
-		"--max-downloads", "1",
-		fmt.Sprintf("ytsearch10:%s", search),
+		"--newline",
+		"--progress-template", "download:"+progressLinePrefix+" %(progress._percent_str)s",
+		sourceURL,
 	)
 }
 
@@ -250,10 +437,28 @@ func writeID3Tags(track *core.Record, tmpFile, fileID, dir string) error {
 	fullDate := track.GetString("release_date") // "2021-08-23"
 	year := ""
 	if len(fullDate) >= 4 {
-			year = fullDate[:4] // "2021"
+		year = fullDate[:4] // "2021"
 	}
 	tag.SetYear(year)
 
+	// Track/disc position (TRCK, TPOS), album artist (TPE2) and ISRC (TSRC)
+	if n := track.GetInt("track_number"); n > 0 {
+		trck := strconv.Itoa(n)
+		if total := track.GetInt("total_tracks"); total > 0 {
+			trck += "/" + strconv.Itoa(total)
+		}
+		tag.AddTextFrame("TRCK", tag.DefaultEncoding(), trck)
+	}
+	if n := track.GetInt("disc_number"); n > 0 {
+		tag.AddTextFrame("TPOS", tag.DefaultEncoding(), strconv.Itoa(n))
+	}
+	if albumArtist := track.GetString("album_artist"); albumArtist != "" {
+		tag.AddTextFrame("TPE2", tag.DefaultEncoding(), albumArtist)
+	}
+	if isrc := track.GetString("isrc"); isrc != "" {
+		tag.AddTextFrame("TSRC", tag.DefaultEncoding(), isrc)
+	}
+
 	// Album art
 	if len(track.GetString("cover_url")) > 0 {
 		coverURL := track.GetString("cover_url")
@@ -272,21 +477,28 @@ func writeID3Tags(track *core.Record, tmpFile, fileID, dir string) error {
 
 	// Extra data
 	tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
-        Encoding:    tag.DefaultEncoding(),
-        Description: "SPOTIFY_ID",
-        Value:       track.GetString("spotify_track_id"),
+		Encoding:    tag.DefaultEncoding(),
+		Description: "SPOTIFY_ID",
+		Value:       track.GetString("spotify_track_id"),
 	})
 
 	tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
-        Encoding:    tag.DefaultEncoding(),
-        Description: "ALBUM_ID",
-        Value:       track.GetString("album_id"),
+		Encoding:    tag.DefaultEncoding(),
+		Description: "ALBUM_ID",
+		Value:       track.GetString("album_id"),
 	})
 	tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
-        Encoding:    tag.DefaultEncoding(),
-        Description: "ARTIST_ID",
-        Value:       track.GetString("artist_id"),
+		Encoding:    tag.DefaultEncoding(),
+		Description: "ARTIST_ID",
+		Value:       track.GetString("artist_id"),
 	})
+	if sourceURL := track.GetString("source_url"); sourceURL != "" {
+		tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+			Encoding:    tag.DefaultEncoding(),
+			Description: "SOURCE_URL",
+			Value:       sourceURL,
+		})
+	}
 
 	return tag.Save()
 }
@@ -298,6 +510,11 @@ func downloadFile(url, dest string) error {
 	}
 	defer resp.Body.Close()
 
+	// e.g. a release without cover art - don't embed the error page
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
 	out, err := os.Create(dest)
 	if err != nil {
 		return err
@@ -312,25 +529,68 @@ func downloadFile(url, dest string) error {
 //  SAVE RECORD TO POCKETBASE
 // ======================================================================
 
-func saveTrackRecord(app core.App, t *SpotifyTrack) (*core.Record, error) {
+func saveTrackRecord(app core.App, t *TrackMetadata, priority int) (*core.Record, error) {
 	col, err := app.FindCollectionByNameOrId("tracks")
 	if err != nil {
 		return nil, err
 	}
 
 	record := core.NewRecord(col)
-	record.Set("download_status", "queued");
+	record.Set("download_status", "queued")
+	record.Set("priority", priority)
+
+	setTrackMetadata(record, t)
+
+	// Artist/album records (the joined strings are kept for older clients)
+	if err := linkCatalog(app, record, t); err != nil {
+		return nil, err
+	}
+
+	if err := app.Save(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Track fields set from the provider metadata (and linkCatalog)
+var trackMetadataFields = []string{
+	"provider", "provider_id", "spotify_track_id",
+	"name", "duration", "release_date", "track_number", "disc_number", "isrc", "explicit", "popularity",
+	"album", "album_id", "album_type", "total_tracks", "album_artist", "album_record",
+	"artist_id", "artist", "artists",
+	"cover_url",
+}
+
+// setTrackMetadata sets the trackMetadataFields of the (unsaved) record,
+// except for the artist/album relations which are set by linkCatalog
+func setTrackMetadata(record *core.Record, t *TrackMetadata) {
+	// Identity
+	record.Set("provider", t.Provider)
+	record.Set("provider_id", t.ProviderID)
+	record.Set("spotify_track_id", t.SpotifyID)
 
 	// Track data
-	record.Set("spotify_track_id", t.ID)
 	record.Set("name", t.Name)
 	record.Set("duration", t.DurationMs)
 	record.Set("release_date", t.Album.ReleaseDate)
 	record.Set("track_number", t.TrackNumber)
+	record.Set("disc_number", t.DiscNumber)
+	record.Set("isrc", t.ISRC)
+	record.Set("explicit", t.Explicit)
+	record.Set("popularity", t.Popularity)
 
 	// Album data
 	record.Set("album", t.Album.Name)
 	record.Set("album_id", t.Album.ID)
+	record.Set("album_type", t.Album.Type)
+	record.Set("total_tracks", t.Album.TotalTracks)
+
+	albumArtists := []string{}
+	for _, a := range t.Album.Artists {
+		albumArtists = append(albumArtists, a.Name)
+	}
+	record.Set("album_artist", strings.Join(albumArtists, ", "))
 
 	// Artists data
 	artistIds := []string{}
@@ -343,15 +603,7 @@ func saveTrackRecord(app core.App, t *SpotifyTrack) (*core.Record, error) {
 	record.Set("artist", strings.Join(artistNames, ", "))
 
 	// Cover image
-	if len(t.Album.Images) > 0 {
-		record.Set("cover_url", t.Album.Images[0].URL)
-	}
-
-	if err := app.Save(record); err != nil {
-		return nil, err
-	}
-
-	return record, nil
+	record.Set("cover_url", t.Album.CoverURL)
 }
 
 func updateTrackRecord(app core.App, track *core.Record, localPath string) (*core.Record, error) {
@@ -361,7 +613,7 @@ func updateTrackRecord(app core.App, track *core.Record, localPath string) (*cor
 	}
 
 	track.Set("file", file) // field name must match your schema
-	if err := app.Save(track); err != nil {
+	if err := saveLeasedTrack(app, track, track.GetString("lease_owner")); err != nil {
 		return nil, err
 	}
 
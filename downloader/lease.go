@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// How long a claimed track stays owned by a worker without a heartbeat
+const LeaseDuration = 2 * time.Minute
+
+// How often a running download renews its lease
+const LeaseHeartbeatInterval = 30 * time.Second
+
+// WorkerID identifies this process as a lease owner. It is unique per start,
+// so leases left behind by a previous (crashed) run are never mistaken as ours.
+var WorkerID = newWorkerID()
+
+var ErrTrackNotClaimable = errors.New("track is not queued anymore")
+
+var ErrLeaseLost = errors.New("track lease is not owned by this worker anymore")
+
+func newWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+
+	return fmt.Sprintf("%s-%s", host, uuid.New().String()[:8])
+}
+
+// ======================================================================
+//  CLAIM / HEARTBEAT / RELEASE
+// ======================================================================
+
+// ClaimTrack flips a queued track to "downloading" and takes its lease.
+// The status is re-checked inside a transaction so two workers can never
+// claim the same row.
+func ClaimTrack(app core.App, trackID string, owner string) (*core.Record, error) {
+	var claimed *core.Record
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		track, err := txApp.FindRecordById("tracks", trackID)
+		if err != nil {
+			return err
+		}
+
+		if track.GetString("download_status") != "queued" {
+			return ErrTrackNotClaimable
+		}
+
+		track.Set("download_status", "downloading")
+		track.Set("lease_owner", owner)
+		track.Set("lease_expires_at", types.NowDateTime().Add(LeaseDuration))
+		track.Set("attempts", track.GetInt("attempts")+1)
+
+		if err := txApp.Save(track); err != nil {
+			return err
+		}
+
+		claimed = track
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+// RenewLease pushes the lease expiry forward, as long as we still own it.
+// Only the lease column is written, so the heartbeat can never overwrite
+// changes the download itself is saving on the same record.
+func RenewLease(app core.App, trackID string, owner string) error {
+	result, err := app.DB().Update(
+		"tracks",
+		dbx.Params{"lease_expires_at": types.NowDateTime().Add(LeaseDuration).String()},
+		dbx.HashExp{"id": trackID, "lease_owner": owner},
+	).Execute()
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: track %s, owner %q", ErrLeaseLost, trackID, owner)
+	}
+
+	return nil
+}
+
+// KeepLeaseAlive renews the lease every LeaseHeartbeatInterval until the
+// returned stop func is called. onLost is called (once) when the lease turns
+// out to be taken away, e.g. the track got cancelled by another process.
+func KeepLeaseAlive(app core.App, trackID string, owner string, onLost func()) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(LeaseHeartbeatInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := RenewLease(app, trackID, owner)
+				if errors.Is(err, ErrLeaseLost) {
+					log.Printf("Lost the lease for track %s, stopping its download", trackID)
+					onLost()
+					return
+				}
+				if err != nil {
+					log.Printf("Failed to renew lease for track %s: %v", trackID, err)
+				}
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// saveLeasedTrack saves a track that is downloaded under owner's lease. The
+// lease is re-checked inside a transaction, so a track that got cancelled or
+// reclaimed meanwhile is never overwritten with our stale copy. Without an
+// owner the record is saved as is.
+func saveLeasedTrack(app core.App, track *core.Record, owner string) error {
+	if owner == "" {
+		return app.Save(track)
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		current, err := txApp.FindRecordById("tracks", track.Id)
+		if err != nil {
+			return err
+		}
+
+		if current.GetString("download_status") != "downloading" || current.GetString("lease_owner") != owner {
+			return fmt.Errorf("%w: track %s, owner %q", ErrLeaseLost, track.Id, owner)
+		}
+
+		// the heartbeat renews the lease in the db only, don't move it back
+		if track.GetString("lease_owner") != "" {
+			track.Set("lease_expires_at", current.Get("lease_expires_at"))
+		}
+
+		return txApp.Save(track)
+	})
+}
+
+// ReleaseLease clears the lease fields (the caller still has to save the record)
+func ReleaseLease(track *core.Record) {
+	track.Set("lease_owner", "")
+	track.Set("lease_expires_at", "")
+}
+
+// ======================================================================
+//  RECLAIM EXPIRED LEASES
+// ======================================================================
+
+// ReclaimExpiredLeases puts every "downloading" track whose lease has run out
+// (or that never had one) back into the queue. Tracks that already used up all
+// their attempts are moved to "dead" instead, so a track that keeps crashing
+// the worker can't loop forever. Returns the number of reclaimed tracks.
+func ReclaimExpiredLeases(app core.App, policy RetryPolicy) (int, error) {
+	tracks, err := app.FindRecordsByFilter(
+		"tracks",
+		"download_status='downloading' && (lease_expires_at='' || lease_expires_at<@now)",
+		"",
+		0,
+		0,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	reclaimed := 0
+	for _, track := range tracks {
+		if policy.Exhausted(track.GetInt("attempts")) {
+			track.Set("download_status", "dead")
+			track.Set("last_error", "lease expired while downloading")
+		} else {
+			track.Set("download_status", "queued")
+		}
+		ReleaseLease(track)
+
+		if err := app.Save(track); err != nil {
+			log.Printf("Failed to reclaim lease for track %s: %v", track.Id, err)
+			continue
+		}
+		reclaimed++
+	}
+
+	if reclaimed > 0 {
+		NotifyQueue()
+	}
+
+	return reclaimed, nil
+}
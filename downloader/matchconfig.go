@@ -0,0 +1,349 @@
+package downloader
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ToleranceLimit is how far a candidate's duration may be off the track
+// duration in one direction. The larger of the two values applies.
+type ToleranceLimit struct {
+	Seconds float64
+	// Share of the track duration (so long tracks get a wider window)
+	Percent float64
+}
+
+// Allowed returns the max delta (in seconds) for a track of the given duration
+func (l ToleranceLimit) Allowed(durationSeconds float64) float64 {
+	return math.Max(l.Seconds, durationSeconds*l.Percent/100)
+}
+
+// DurationTolerance limits shorter and longer candidates separately (e.g. an
+// official video with an intro is longer than the track, but hardly shorter)
+type DurationTolerance struct {
+	Under ToleranceLimit
+	Over  ToleranceLimit
+}
+
+// Allowed returns the max delta (in seconds) of a candidate of the given
+// duration for a track of the given duration
+func (t DurationTolerance) Allowed(durationSeconds, candidateSeconds float64) float64 {
+	if candidateSeconds < durationSeconds {
+		return t.Under.Allowed(durationSeconds)
+	}
+	return t.Over.Allowed(durationSeconds)
+}
+
+// ParseDurationTolerance parses a comma separated list of "60s" and "10%"
+// values. Values prefixed with "-" limit shorter candidates only, the ones
+// prefixed with "+" longer candidates only, e.g. "-30s,+60s,10%".
+func ParseDurationTolerance(s string) (DurationTolerance, error) {
+	var t DurationTolerance
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		value := part
+		limits := []*ToleranceLimit{&t.Under, &t.Over}
+		switch {
+		case strings.HasPrefix(part, "-"):
+			value, limits = part[1:], limits[:1]
+		case strings.HasPrefix(part, "+"):
+			value, limits = part[1:], limits[1:]
+		}
+
+		var err error
+		switch {
+		case strings.HasSuffix(value, "%"):
+			var percent float64
+			percent, err = parseToleranceValue(strings.TrimSuffix(value, "%"))
+			for _, limit := range limits {
+				limit.Percent = percent
+			}
+		case strings.HasSuffix(value, "s"):
+			var seconds float64
+			seconds, err = parseToleranceValue(strings.TrimSuffix(value, "s"))
+			for _, limit := range limits {
+				limit.Seconds = seconds
+			}
+		default:
+			err = fmt.Errorf("missing unit")
+		}
+		if err != nil {
+			return DurationTolerance{}, fmt.Errorf("invalid duration tolerance %q: %w", part, err)
+		}
+	}
+
+	if t.Under == (ToleranceLimit{}) {
+		return DurationTolerance{}, fmt.Errorf("invalid duration tolerance %q: missing the limit for shorter candidates", s)
+	}
+	if t.Over == (ToleranceLimit{}) {
+		return DurationTolerance{}, fmt.Errorf("invalid duration tolerance %q: missing the limit for longer candidates", s)
+	}
+
+	return t, nil
+}
+
+// parseToleranceValue parses a single tolerance number, which must be > 0
+func parseToleranceValue(s string) (float64, error) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return 0, fmt.Errorf("must be a positive number")
+	}
+	return value, nil
+}
+
+// MatchConfig controls how the audio sources are searched and how the
+// candidates are scored
+type MatchConfig struct {
+	// Tried in order until a source has an acceptable candidate.
+	// Placeholders: {artist}, {title}, {album}, {year}
+	QueryTemplates []string
+	// Replace QueryTemplates for a single source
+	SourceQueryTemplates map[string][]string
+
+	// Candidates outside the tolerance are rejected
+	Tolerance        DurationTolerance
+	SourceTolerances map[string]DurationTolerance
+
+	// Title words that make a candidate more likely (e.g. "official audio")
+	IncludeKeywords []string
+	// Title words that mark an alternative version - unless the track itself has them in its name
+	ExcludeKeywords []string
+}
+
+var defaultMatchConfig = MatchConfig{
+	QueryTemplates: []string{
+		"{artist} {title} official audio",
+		"{artist} {title}",
+	},
+	// "official audio" is a YouTube thing
+	SourceQueryTemplates: map[string][]string{
+		SourceSoundCloud: {"{artist} {title}", "{title}"},
+		SourceBandcamp:   {"{artist} {title}", "{title}"},
+	},
+	Tolerance: DurationTolerance{
+		Under: ToleranceLimit{Seconds: 30, Percent: 10},
+		Over:  ToleranceLimit{Seconds: 60, Percent: 10},
+	},
+	SourceTolerances: map[string]DurationTolerance{},
+	IncludeKeywords:  []string{"official audio"},
+	ExcludeKeywords: []string{
+		"live", "cover", "karaoke", "instrumental", "nightcore", "sped up", "slowed",
+		"reverb", "remix", "acoustic", "8d", "1 hour",
+	},
+}
+
+// Key of the settings record overriding the match config
+const matchSettingsKey = "match"
+
+var currentMatchConfig atomic.Pointer[MatchConfig]
+
+// ReloadMatchConfig loads the match config (see LoadMatchConfig) used by the
+// following downloads
+func ReloadMatchConfig(app core.App) {
+	cfg := LoadMatchConfig(app)
+	currentMatchConfig.Store(&cfg)
+}
+
+// CurrentMatchConfig returns the config loaded by ReloadMatchConfig (the
+// default one if it was never loaded)
+func CurrentMatchConfig() MatchConfig {
+	if cfg := currentMatchConfig.Load(); cfg != nil {
+		return *cfg
+	}
+	return defaultMatchConfig
+}
+
+// LoadMatchConfig returns the default config overridden by the env vars (see
+// matchConfigFromEnv) and then by the value of the "match" record of the
+// settings collection (see matchSettings)
+func LoadMatchConfig(app core.App) MatchConfig {
+	cfg := matchConfigFromEnv()
+
+	record, err := app.FindFirstRecordByData("settings", "key", matchSettingsKey)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("Failed to load the match settings:", err)
+		}
+		return cfg
+	}
+
+	var settings matchSettings
+	if err := record.UnmarshalJSONField("value", &settings); err != nil {
+		log.Println("Ignoring the match settings:", err)
+		return cfg
+	}
+	settings.apply(&cfg)
+
+	return cfg
+}
+
+// matchSettings is the value of the "match" settings record, e.g.
+//
+//	{
+//	  "query_templates": ["{artist} {title} official audio", "{artist} {title}"],
+//	  "source_query_templates": {"bandcamp": ["{title}"]},
+//	  "duration_tolerance": "-30s,+60s,10%",
+//	  "source_duration_tolerances": {"soundcloud": "30s"},
+//	  "include_keywords": ["official audio"],
+//	  "exclude_keywords": ["live", "cover"]
+//	}
+//
+// Missing values keep the env/default ones.
+type matchSettings struct {
+	QueryTemplates           []string            `json:"query_templates"`
+	SourceQueryTemplates     map[string][]string `json:"source_query_templates"`
+	DurationTolerance        string              `json:"duration_tolerance"`
+	SourceDurationTolerances map[string]string   `json:"source_duration_tolerances"`
+	// pointers, so an empty list can clear the keywords
+	IncludeKeywords *[]string `json:"include_keywords"`
+	ExcludeKeywords *[]string `json:"exclude_keywords"`
+}
+
+// apply overrides cfg with the set values (invalid tolerances are logged and skipped)
+func (s matchSettings) apply(cfg *MatchConfig) {
+	if len(s.QueryTemplates) > 0 {
+		cfg.QueryTemplates = s.QueryTemplates
+	}
+	for source, templates := range s.SourceQueryTemplates {
+		cfg.SourceQueryTemplates[source] = templates
+	}
+
+	if s.DurationTolerance != "" {
+		if t, err := ParseDurationTolerance(s.DurationTolerance); err == nil {
+			cfg.Tolerance = t
+		} else {
+			log.Println("Ignoring the duration_tolerance setting:", err)
+		}
+	}
+	for source, v := range s.SourceDurationTolerances {
+		if t, err := ParseDurationTolerance(v); err == nil {
+			cfg.SourceTolerances[source] = t
+		} else {
+			log.Printf("Ignoring the %s duration tolerance setting: %v", source, err)
+		}
+	}
+
+	if s.IncludeKeywords != nil {
+		cfg.IncludeKeywords = *s.IncludeKeywords
+	}
+	if s.ExcludeKeywords != nil {
+		cfg.ExcludeKeywords = *s.ExcludeKeywords
+	}
+}
+
+// matchConfigFromEnv returns the default config overridden by the env vars:
+//   - SEARCH_QUERY_TEMPLATES: "|" separated templates
+//   - SEARCH_QUERY_TEMPLATES_<SOURCE>: per source, e.g. SEARCH_QUERY_TEMPLATES_BANDCAMP="{title}"
+//   - DURATION_TOLERANCE: e.g. "-30s,+60s,10%", see ParseDurationTolerance
+//   - DURATION_TOLERANCE_<SOURCE>: per source, e.g. DURATION_TOLERANCE_SOUNDCLOUD="30s"
+//   - MATCH_INCLUDE_KEYWORDS, MATCH_EXCLUDE_KEYWORDS: comma separated keywords
+func matchConfigFromEnv() MatchConfig {
+	cfg := defaultMatchConfig
+	cfg.SourceQueryTemplates = maps.Clone(defaultMatchConfig.SourceQueryTemplates)
+	cfg.SourceTolerances = map[string]DurationTolerance{}
+
+	if v := os.Getenv("SEARCH_QUERY_TEMPLATES"); v != "" {
+		cfg.QueryTemplates = splitList(v, "|")
+	}
+	for _, source := range defaultAudioSourceOrder {
+		if v := os.Getenv("SEARCH_QUERY_TEMPLATES_" + strings.ToUpper(source)); v != "" {
+			cfg.SourceQueryTemplates[source] = splitList(v, "|")
+		}
+	}
+
+	if v := os.Getenv("DURATION_TOLERANCE"); v != "" {
+		if t, err := ParseDurationTolerance(v); err == nil {
+			cfg.Tolerance = t
+		} else {
+			log.Println("Ignoring DURATION_TOLERANCE:", err)
+		}
+	}
+	for _, source := range defaultAudioSourceOrder {
+		name := "DURATION_TOLERANCE_" + strings.ToUpper(source)
+		if v := os.Getenv(name); v != "" {
+			if t, err := ParseDurationTolerance(v); err == nil {
+				cfg.SourceTolerances[source] = t
+			} else {
+				log.Printf("Ignoring %s: %v", name, err)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("MATCH_INCLUDE_KEYWORDS"); ok {
+		cfg.IncludeKeywords = splitList(v, ",")
+	}
+	if v, ok := os.LookupEnv("MATCH_EXCLUDE_KEYWORDS"); ok {
+		cfg.ExcludeKeywords = splitList(v, ",")
+	}
+
+	return cfg
+}
+
+// ToleranceFor returns the duration tolerance of the audio source
+func (c MatchConfig) ToleranceFor(source string) DurationTolerance {
+	if t, ok := c.SourceTolerances[source]; ok {
+		return t
+	}
+	return c.Tolerance
+}
+
+// QueryTemplatesFor returns the query templates of the audio source
+func (c MatchConfig) QueryTemplatesFor(source string) []string {
+	if templates, ok := c.SourceQueryTemplates[source]; ok && len(templates) > 0 {
+		return templates
+	}
+	return c.QueryTemplates
+}
+
+// Queries renders the query templates of the audio source for the track
+// (duplicates are skipped)
+func (c MatchConfig) Queries(track *core.Record, source string) []string {
+	year := track.GetString("release_date")
+	if len(year) > 4 {
+		year = year[:4]
+	}
+
+	replacer := strings.NewReplacer(
+		"{artist}", track.GetString("artist"),
+		"{title}", cleanTrackName(track.GetString("name")),
+		"{album}", track.GetString("album"),
+		"{year}", year,
+	)
+
+	queries := []string{}
+	seen := map[string]struct{}{}
+	for _, template := range c.QueryTemplatesFor(source) {
+		query := strings.Join(strings.Fields(replacer.Replace(template)), " ")
+		if _, ok := seen[query]; ok || query == "" {
+			continue
+		}
+		seen[query] = struct{}{}
+		queries = append(queries, query)
+	}
+
+	return queries
+}
+
+func splitList(s, sep string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
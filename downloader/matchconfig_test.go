@@ -0,0 +1,275 @@
+package downloader
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestMatchConfigQueries(t *testing.T) {
+	track := newTestTrack("Blinding Lights (Remastered)", "The Weeknd", 200000)
+	track.Set("album", "After Hours")
+	track.Set("release_date", "2020-03-20")
+
+	cfg := MatchConfig{
+		QueryTemplates: []string{
+			"{artist} {title} official audio",
+			"{artist}  {title}",
+			"{artist} {title}",
+			"{title} {album} {year}",
+			"{unknown}",
+			" ",
+		},
+		SourceQueryTemplates: map[string][]string{
+			SourceBandcamp:   {"{title}"},
+			SourceSoundCloud: {},
+		},
+	}
+
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{SourceYouTube, []string{
+			"The Weeknd Blinding Lights official audio",
+			"The Weeknd Blinding Lights",
+			"Blinding Lights After Hours 2020",
+			"{unknown}",
+		}},
+		{SourceBandcamp, []string{"Blinding Lights"}},
+		// empty templates fall back to the default ones
+		{SourceSoundCloud, []string{
+			"The Weeknd Blinding Lights official audio",
+			"The Weeknd Blinding Lights",
+			"Blinding Lights After Hours 2020",
+			"{unknown}",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			if got := cfg.Queries(track, tt.source); !slices.Equal(got, tt.want) {
+				t.Errorf("Queries(%s) = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultQueryTemplates(t *testing.T) {
+	// only YouTube is searched for "official audio"
+	for _, source := range defaultAudioSourceOrder {
+		templates := defaultMatchConfig.QueryTemplatesFor(source)
+		hasOfficial := slices.ContainsFunc(templates, func(s string) bool {
+			return s == "{artist} {title} official audio"
+		})
+		wantOfficial := source == SourceYouTube || source == SourceYouTubeMusic
+		if hasOfficial != wantOfficial {
+			t.Errorf("%s templates %q, want official audio = %v", source, templates, wantOfficial)
+		}
+	}
+}
+
+func TestParseDurationTolerance(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    DurationTolerance
+		wantErr bool
+	}{
+		{"60s", DurationTolerance{Under: ToleranceLimit{Seconds: 60}, Over: ToleranceLimit{Seconds: 60}}, false},
+		{"10%", DurationTolerance{Under: ToleranceLimit{Percent: 10}, Over: ToleranceLimit{Percent: 10}}, false},
+		{"60s,10%", DurationTolerance{Under: ToleranceLimit{60, 10}, Over: ToleranceLimit{60, 10}}, false},
+		{" 2.5s , 0.5% ", DurationTolerance{Under: ToleranceLimit{2.5, 0.5}, Over: ToleranceLimit{2.5, 0.5}}, false},
+		{"-60s,+30s", DurationTolerance{Under: ToleranceLimit{Seconds: 60}, Over: ToleranceLimit{Seconds: 30}}, false},
+		{"-30s,+60s,10%", DurationTolerance{Under: ToleranceLimit{30, 10}, Over: ToleranceLimit{60, 10}}, false},
+		{"-10%,+5s", DurationTolerance{Under: ToleranceLimit{Percent: 10}, Over: ToleranceLimit{Seconds: 5}}, false},
+		{"-60s", DurationTolerance{}, true},
+		{"+30s", DurationTolerance{}, true},
+		{"0s", DurationTolerance{}, true},
+		{"-0s,+5s", DurationTolerance{}, true},
+		{"--5s,+5s", DurationTolerance{}, true},
+		{"NaNs", DurationTolerance{}, true},
+		{"Inf%", DurationTolerance{}, true},
+		{"60", DurationTolerance{}, true},
+		{"60m", DurationTolerance{}, true},
+		{"abc%", DurationTolerance{}, true},
+		{"60s,", DurationTolerance{}, true},
+		{"", DurationTolerance{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDurationTolerance(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseDurationTolerance(%q) = %+v, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDurationTolerance(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseDurationTolerance(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationToleranceAllowed(t *testing.T) {
+	tolerance := DurationTolerance{Under: ToleranceLimit{30, 10}, Over: ToleranceLimit{60, 0}}
+
+	tests := []struct {
+		duration  float64
+		candidate float64
+		want      float64
+	}{
+		{200, 190, 30},
+		{600, 500, 60},
+		{200, 200, 60},
+		{200, 260, 60},
+		{1200, 1300, 60},
+	}
+
+	for _, tt := range tests {
+		if got := tolerance.Allowed(tt.duration, tt.candidate); got != tt.want {
+			t.Errorf("Allowed(%v, %v) = %v, want %v", tt.duration, tt.candidate, got, tt.want)
+		}
+	}
+}
+
+func TestScoreCandidateWithoutTolerance(t *testing.T) {
+	cfg := MatchConfig{Tolerance: DurationTolerance{}}
+	track := newTestTrack("Blinding Lights", "The Weeknd", 200000)
+
+	tests := []struct {
+		duration float64
+		want     float64
+	}{
+		{200, 40 + 20 + 25},
+		{201, -100},
+	}
+
+	for _, tt := range tests {
+		got := scoreCandidate(cfg, track, Candidate{Title: "Blinding Lights", Channel: "The Weeknd", Duration: tt.duration})
+		if got != tt.want {
+			t.Errorf("scoreCandidate(duration %v) = %v, want %v", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestMatchSettingsApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		check func(t *testing.T, cfg MatchConfig)
+	}{
+		{
+			"empty keeps everything",
+			`{}`,
+			func(t *testing.T, cfg MatchConfig) {
+				if !slices.Equal(cfg.QueryTemplates, defaultMatchConfig.QueryTemplates) ||
+					cfg.Tolerance != defaultMatchConfig.Tolerance ||
+					!slices.Equal(cfg.ExcludeKeywords, defaultMatchConfig.ExcludeKeywords) {
+					t.Fatalf("config changed: %+v", cfg)
+				}
+			},
+		},
+		{
+			"query templates",
+			`{"query_templates": ["{title}"], "source_query_templates": {"soundcloud": ["{artist}"]}}`,
+			func(t *testing.T, cfg MatchConfig) {
+				if got := cfg.QueryTemplatesFor(SourceYouTube); !slices.Equal(got, []string{"{title}"}) {
+					t.Errorf("youtube templates = %q", got)
+				}
+				if got := cfg.QueryTemplatesFor(SourceSoundCloud); !slices.Equal(got, []string{"{artist}"}) {
+					t.Errorf("soundcloud templates = %q", got)
+				}
+				if got := cfg.QueryTemplatesFor(SourceBandcamp); !slices.Equal(got, defaultMatchConfig.SourceQueryTemplates[SourceBandcamp]) {
+					t.Errorf("bandcamp templates = %q", got)
+				}
+			},
+		},
+		{
+			"tolerances",
+			`{"duration_tolerance": "-10s,+20s", "source_duration_tolerances": {"soundcloud": "5%", "bandcamp": "0s"}}`,
+			func(t *testing.T, cfg MatchConfig) {
+				want := DurationTolerance{Under: ToleranceLimit{Seconds: 10}, Over: ToleranceLimit{Seconds: 20}}
+				if got := cfg.ToleranceFor(SourceYouTube); got != want {
+					t.Errorf("youtube tolerance = %+v, want %+v", got, want)
+				}
+				want = DurationTolerance{Under: ToleranceLimit{Percent: 5}, Over: ToleranceLimit{Percent: 5}}
+				if got := cfg.ToleranceFor(SourceSoundCloud); got != want {
+					t.Errorf("soundcloud tolerance = %+v, want %+v", got, want)
+				}
+				// invalid ones are skipped
+				want = DurationTolerance{Under: ToleranceLimit{Seconds: 10}, Over: ToleranceLimit{Seconds: 20}}
+				if got := cfg.ToleranceFor(SourceBandcamp); got != want {
+					t.Errorf("bandcamp tolerance = %+v, want %+v", got, want)
+				}
+			},
+		},
+		{
+			"invalid tolerance is skipped",
+			`{"duration_tolerance": "NaN%"}`,
+			func(t *testing.T, cfg MatchConfig) {
+				if cfg.Tolerance != defaultMatchConfig.Tolerance {
+					t.Fatalf("tolerance = %+v, want the default", cfg.Tolerance)
+				}
+			},
+		},
+		{
+			"keywords can be cleared",
+			`{"include_keywords": ["topic"], "exclude_keywords": []}`,
+			func(t *testing.T, cfg MatchConfig) {
+				if !slices.Equal(cfg.IncludeKeywords, []string{"topic"}) || len(cfg.ExcludeKeywords) != 0 {
+					t.Fatalf("keywords = %q / %q", cfg.IncludeKeywords, cfg.ExcludeKeywords)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var settings matchSettings
+			if err := json.Unmarshal([]byte(tt.value), &settings); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := matchConfigFromEnv()
+			settings.apply(&cfg)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestMatchConfigFromEnv(t *testing.T) {
+	t.Setenv("SEARCH_QUERY_TEMPLATES", "{title} | {artist} {title} |")
+	t.Setenv("SEARCH_QUERY_TEMPLATES_BANDCAMP", "{artist}")
+	t.Setenv("DURATION_TOLERANCE", "-5s,+90s")
+	t.Setenv("DURATION_TOLERANCE_SOUNDCLOUD", "0s")
+	t.Setenv("MATCH_EXCLUDE_KEYWORDS", "")
+
+	cfg := matchConfigFromEnv()
+
+	if !slices.Equal(cfg.QueryTemplates, []string{"{title}", "{artist} {title}"}) {
+		t.Errorf("QueryTemplates = %q", cfg.QueryTemplates)
+	}
+	if got := cfg.QueryTemplatesFor(SourceBandcamp); !slices.Equal(got, []string{"{artist}"}) {
+		t.Errorf("bandcamp templates = %q", got)
+	}
+	want := DurationTolerance{Under: ToleranceLimit{Seconds: 5}, Over: ToleranceLimit{Seconds: 90}}
+	if cfg.Tolerance != want {
+		t.Errorf("Tolerance = %+v, want %+v", cfg.Tolerance, want)
+	}
+	if _, ok := cfg.SourceTolerances[SourceSoundCloud]; ok {
+		t.Error("the invalid soundcloud tolerance wasn't skipped")
+	}
+	if len(cfg.ExcludeKeywords) != 0 {
+		t.Errorf("ExcludeKeywords = %q, want none", cfg.ExcludeKeywords)
+	}
+
+	// the defaults aren't modified
+	if len(defaultMatchConfig.SourceQueryTemplates[SourceBandcamp]) != 2 {
+		t.Errorf("default bandcamp templates changed: %q", defaultMatchConfig.SourceQueryTemplates[SourceBandcamp])
+	}
+}
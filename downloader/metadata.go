@@ -0,0 +1,106 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Metadata providers stored in the track "provider" field
+const (
+	ProviderSpotify     = "spotify"
+	ProviderMusicBrainz = "musicbrainz"
+)
+
+// The provider doesn't know the requested track (wrapped by the provider specific errors)
+var ErrMetadataNotFound = errors.New("not found")
+
+// TrackMetadata is the provider-neutral track info a track record is created from
+type TrackMetadata struct {
+	// Identity of the track at the provider it was looked up with
+	Provider   string
+	ProviderID string
+	// Only known for tracks looked up on Spotify
+	SpotifyID string
+
+	Name        string
+	DurationMs  int
+	TrackNumber int
+	DiscNumber  int
+	ISRC        string
+	Explicit    bool
+	// 0-100, only known for tracks looked up on Spotify
+	Popularity int
+
+	Artists []ArtistMetadata
+	Album   AlbumMetadata
+}
+
+type ArtistMetadata struct {
+	ID   string
+	Name string
+}
+
+type AlbumMetadata struct {
+	ID          string
+	Name        string
+	ReleaseDate string
+	CoverURL    string
+	// "album", "single", "compilation"...
+	Type        string
+	TotalTracks int
+	Artists     []ArtistMetadata
+}
+
+// MetadataProvider looks up track metadata at a single service.
+//
+// Lookups of unknown tracks return an error wrapping ErrMetadataNotFound,
+// failed requests an error wrapping ErrUpstream.
+type MetadataProvider interface {
+	// Name is stored as the track "provider"
+	Name() string
+	// LookupID finds a track by its id at the provider
+	LookupID(ctx context.Context, id string) (*TrackMetadata, error)
+	// LookupISRC finds a recording of the ISRC
+	LookupISRC(ctx context.Context, isrc string) (*TrackMetadata, error)
+	// Search returns up to limit tracks matching a free-text query
+	Search(ctx context.Context, query string, limit int) ([]*TrackMetadata, error)
+}
+
+var (
+	metadataProvidersMu sync.RWMutex
+	metadataProviders   = map[string]MetadataProvider{}
+)
+
+func init() {
+	RegisterMetadataProvider(SpotifyProvider{})
+	RegisterMetadataProvider(NewMusicBrainzProvider())
+}
+
+// RegisterMetadataProvider adds (or replaces) a provider under its name
+func RegisterMetadataProvider(p MetadataProvider) {
+	metadataProvidersMu.Lock()
+	defer metadataProvidersMu.Unlock()
+
+	metadataProviders[p.Name()] = p
+}
+
+// GetMetadataProvider returns the provider registered under the name ("" = spotify)
+func GetMetadataProvider(name string) (MetadataProvider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = ProviderSpotify
+	}
+
+	metadataProvidersMu.RLock()
+	defer metadataProvidersMu.RUnlock()
+
+	p, ok := metadataProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown metadata provider %q", ErrInvalidRequest, name)
+	}
+
+	return p, nil
+}
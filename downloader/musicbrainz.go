@@ -0,0 +1,281 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMusicBrainzBaseURL = "https://musicbrainz.org/ws/2"
+	// MusicBrainz asks for a meaningful User-Agent (MUSICBRAINZ_USER_AGENT env var)
+	defaultMusicBrainzUserAgent = "groovio/1.0 ( https://github.com/DaniZGit/music-downloader )"
+)
+
+// Front cover of a release, served by the Cover Art Archive
+const coverArtArchiveURL = "https://coverartarchive.org/release/%s/front"
+
+// MusicBrainz allows about one request per second per client
+const musicBrainzRequestInterval = time.Second
+
+// Timeout of a single HTTP request to MusicBrainz
+const musicBrainzRequestTimeout = 15 * time.Second
+
+// The recording or ISRC is unknown to MusicBrainz
+var ErrMusicBrainzNotFound = fmt.Errorf("%w on musicbrainz", ErrMetadataNotFound)
+
+// MusicBrainzProvider is the MetadataProvider backed by the MusicBrainz web
+// service. Its ids are recording MBIDs.
+type MusicBrainzProvider struct {
+	// Overridable, e.g. to point the provider at a local httptest server
+	BaseURL    string
+	UserAgent  string
+	HTTPClient *http.Client
+
+	// requests are spaced out to respect the rate limit
+	mu          sync.Mutex
+	lastRequest time.Time
+}
+
+func NewMusicBrainzProvider() *MusicBrainzProvider {
+	p := &MusicBrainzProvider{
+		BaseURL:    DefaultMusicBrainzBaseURL,
+		UserAgent:  defaultMusicBrainzUserAgent,
+		HTTPClient: &http.Client{Timeout: musicBrainzRequestTimeout},
+	}
+	if v := os.Getenv("MUSICBRAINZ_USER_AGENT"); v != "" {
+		p.UserAgent = v
+	}
+
+	return p
+}
+
+func (p *MusicBrainzProvider) Name() string {
+	return ProviderMusicBrainz
+}
+
+func (p *MusicBrainzProvider) LookupID(ctx context.Context, mbid string) (*TrackMetadata, error) {
+	query := url.Values{}
+	query.Set("inc", "artists+releases+release-groups+isrcs+media")
+
+	var recording musicBrainzRecording
+	if err := p.get(ctx, "/recording/"+url.PathEscape(mbid), query, &recording); err != nil {
+		return nil, err
+	}
+
+	return recording.Metadata(), nil
+}
+
+func (p *MusicBrainzProvider) LookupISRC(ctx context.Context, isrc string) (*TrackMetadata, error) {
+	var data struct {
+		Recordings []musicBrainzRecording `json:"recordings"`
+	}
+	if err := p.get(ctx, "/isrc/"+url.PathEscape(isrc), url.Values{}, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Recordings) == 0 {
+		return nil, fmt.Errorf("%w: no recording with isrc %s", ErrMusicBrainzNotFound, isrc)
+	}
+
+	// the ISRC lookup doesn't include the release media, fetch the full recording
+	return p.LookupID(ctx, data.Recordings[0].ID)
+}
+
+func (p *MusicBrainzProvider) Search(ctx context.Context, q string, limit int) ([]*TrackMetadata, error) {
+	query := url.Values{}
+	query.Set("query", q)
+	query.Set("limit", strconv.Itoa(max(1, min(limit, 100))))
+
+	var data struct {
+		Recordings []musicBrainzRecording `json:"recordings"`
+	}
+	if err := p.get(ctx, "/recording", query, &data); err != nil {
+		return nil, err
+	}
+
+	results := make([]*TrackMetadata, 0, len(data.Recordings))
+	for _, r := range data.Recordings {
+		results = append(results, r.Metadata())
+	}
+
+	return results, nil
+}
+
+// get performs a rate limited GET request and decodes the JSON response into out
+func (p *MusicBrainzProvider) get(ctx context.Context, path string, query url.Values, out any) error {
+	if err := p.wait(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrUpstream, err)
+	}
+
+	query.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(p.BaseURL, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", p.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		// 400 = malformed MBID/ISRC
+		return ErrMusicBrainzNotFound
+	case resp.StatusCode >= 400:
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%w: musicbrainz error %d: %s", ErrUpstream, resp.StatusCode, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: musicbrainz response decode error: %w", ErrUpstream, err)
+	}
+
+	return nil
+}
+
+// wait blocks until the next request is allowed by the rate limit
+func (p *MusicBrainzProvider) wait(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if delay := time.Until(p.lastRequest.Add(musicBrainzRequestInterval)); delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	p.lastRequest = time.Now()
+
+	return nil
+}
+
+// ======================================================================
+//  MUSICBRAINZ API MODELS
+// ======================================================================
+
+type musicBrainzArtistCredit struct {
+	Name   string `json:"name"`
+	Artist struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artist"`
+}
+
+type musicBrainzTrack struct {
+	Number   string `json:"number"`
+	Position int    `json:"position"`
+}
+
+type musicBrainzMedium struct {
+	Position   int `json:"position"`
+	TrackCount int `json:"track-count"`
+	// lookups return "tracks", searches "track"
+	Tracks []musicBrainzTrack `json:"tracks"`
+	Track  []musicBrainzTrack `json:"track"`
+}
+
+type musicBrainzRelease struct {
+	ID     string              `json:"id"`
+	Title  string              `json:"title"`
+	Status string              `json:"status"`
+	Date   string              `json:"date"`
+	Media  []musicBrainzMedium `json:"media"`
+
+	ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+	ReleaseGroup struct {
+		PrimaryType string `json:"primary-type"`
+	} `json:"release-group"`
+}
+
+type musicBrainzRecording struct {
+	ID               string                    `json:"id"`
+	Title            string                    `json:"title"`
+	Length           int                       `json:"length"`
+	FirstReleaseDate string                    `json:"first-release-date"`
+	ISRCs            []string                  `json:"isrcs"`
+	ArtistCredit     []musicBrainzArtistCredit `json:"artist-credit"`
+	Releases         []musicBrainzRelease      `json:"releases"`
+}
+
+// Metadata converts the recording (and its first official release) to the provider-neutral metadata
+func (r *musicBrainzRecording) Metadata() *TrackMetadata {
+	meta := &TrackMetadata{
+		Provider:   ProviderMusicBrainz,
+		ProviderID: r.ID,
+		Name:       r.Title,
+		DurationMs: r.Length,
+	}
+	if len(r.ISRCs) > 0 {
+		meta.ISRC = r.ISRCs[0]
+	}
+	meta.Artists = musicBrainzArtists(r.ArtistCredit)
+
+	release := r.release()
+	if release == nil {
+		meta.Album.ReleaseDate = r.FirstReleaseDate
+		return meta
+	}
+
+	meta.Album = AlbumMetadata{
+		ID:          release.ID,
+		Name:        release.Title,
+		ReleaseDate: release.Date,
+		CoverURL:    fmt.Sprintf(coverArtArchiveURL, release.ID),
+		Type:        strings.ToLower(release.ReleaseGroup.PrimaryType),
+		Artists:     musicBrainzArtists(release.ArtistCredit),
+	}
+	if meta.Album.ReleaseDate == "" {
+		meta.Album.ReleaseDate = r.FirstReleaseDate
+	}
+
+	for _, medium := range release.Media {
+		tracks := append(medium.Tracks, medium.Track...)
+		if len(tracks) == 0 {
+			continue
+		}
+		meta.DiscNumber = medium.Position
+		meta.TrackNumber = tracks[0].Position
+		meta.Album.TotalTracks = medium.TrackCount
+		break
+	}
+
+	return meta
+}
+
+func musicBrainzArtists(credits []musicBrainzArtistCredit) []ArtistMetadata {
+	artists := []ArtistMetadata{}
+	for _, credit := range credits {
+		name := credit.Name
+		if name == "" {
+			name = credit.Artist.Name
+		}
+		artists = append(artists, ArtistMetadata{ID: credit.Artist.ID, Name: name})
+	}
+	return artists
+}
+
+// release picks the release the track is stored with - the first official one
+func (r *musicBrainzRecording) release() *musicBrainzRelease {
+	for i := range r.Releases {
+		if r.Releases[i].Status == "Official" {
+			return &r.Releases[i]
+		}
+	}
+	if len(r.Releases) > 0 {
+		return &r.Releases[0]
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMusicBrainzRateLimitWaitIsUpstreamError(t *testing.T) {
+	p := NewMusicBrainzProvider()
+	p.BaseURL = "http://127.0.0.1:0" // never reached
+	p.lastRequest = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	var out struct{}
+	err := p.get(ctx, "/recording/abc", url.Values{}, &out)
+	if !errors.Is(err, ErrUpstream) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get() error = %v, want ErrUpstream wrapping context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var ErrTrackDownloading = errors.New("the track is being downloaded, cancel it first")
+
+// ParseSourceURL validates a track/video URL of one of the audio sources (see
+// AudioSource.MatchURL) and returns the name of the source and the canonical URL
+func ParseSourceURL(raw string) (string, string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", "", fmt.Errorf("%w: url is required", ErrInvalidRequest)
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", fmt.Errorf("%w: invalid url", ErrInvalidRequest)
+	}
+
+	for _, source := range registeredAudioSources() {
+		if sourceURL, ok := source.MatchURL(u); ok {
+			return source.Name(), sourceURL, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w: url doesn't point to a track of a supported audio source", ErrInvalidRequest)
+}
+
+// SetSourceOverride pins the track/video the track is downloaded from and
+// queues it again (already downloaded tracks are downloaded again, replacing
+// the file). An empty URL unpins the source, see ClearSourceOverride.
+// trackRef is anything FindTrack accepts.
+func SetSourceOverride(app core.App, trackRef string, rawURL string) (*core.Record, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return ClearSourceOverride(app, trackRef)
+	}
+
+	_, sourceURL, err := ParseSourceURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := FindTrack(app, trackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if track.GetString("download_status") == "downloading" {
+		return nil, ErrTrackDownloading
+	}
+
+	track.Set("source_override", sourceURL)
+	track.Set("download_status", "queued")
+	track.Set("attempts", 0)
+	track.Set("next_attempt_at", "")
+	track.Set("last_error", "")
+	if err := app.Save(track); err != nil {
+		return nil, err
+	}
+
+	NotifyQueue()
+
+	return track, nil
+}
+
+// ClearSourceOverride unpins the source of the track, so its next download is
+// searched for again. The track isn't re-queued (nor its file replaced).
+func ClearSourceOverride(app core.App, trackRef string) (*core.Record, error) {
+	track, err := FindTrack(app, trackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if track.GetString("download_status") == "downloading" {
+		return nil, ErrTrackDownloading
+	}
+
+	if track.GetString("source_override") == "" {
+		return track, nil
+	}
+
+	track.Set("source_override", "")
+	if err := app.Save(track); err != nil {
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// probeCandidate fetches the info of a single track/video of the source (used
+// for pinned sources, which aren't searched for)
+func probeCandidate(ctx context.Context, source string, sourceURL string) (Candidate, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "yt-dlp", "--dump-json", "--no-playlist", sourceURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return Candidate{}, ctx.Err()
+		}
+		return Candidate{}, fmt.Errorf("yt-dlp probe failed: %w", err)
+	}
+
+	var entry struct {
+		Candidate
+		Uploader string `json:"uploader"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &entry); err != nil {
+		return Candidate{}, fmt.Errorf("yt-dlp probe decode error: %w", err)
+	}
+
+	c := entry.Candidate
+	c.Source = source
+	c.URL = sourceURL
+	if c.Channel == "" {
+		c.Channel = entry.Uploader
+	}
+
+	return c, nil
+}
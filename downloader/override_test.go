@@ -0,0 +1,67 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSourceURL(t *testing.T) {
+	tests := []struct {
+		input   string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		// YouTube
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"youtube.com/watch?v=dQw4w9WgXcQ", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ", SourceYouTube, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://www.youtube.com/watch?v=short", "", "", true},
+		{"https://www.youtube.com/@someone", "", "", true},
+		{"https://www.youtube.com/playlist?list=PL123", "", "", true},
+
+		// YouTube Music
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ&list=RD", SourceYouTubeMusic, "https://music.youtube.com/watch?v=dQw4w9WgXcQ", false},
+		{"https://music.youtube.com/browse/MPREb_123", "", "", true},
+
+		// SoundCloud
+		{"https://soundcloud.com/artist/some-track?in=x", SourceSoundCloud, "https://soundcloud.com/artist/some-track", false},
+		{"https://m.soundcloud.com/artist/some-track/", SourceSoundCloud, "https://soundcloud.com/artist/some-track", false},
+		{"https://soundcloud.com/artist", "", "", true},
+		{"https://soundcloud.com/artist/sets/some-album", "", "", true},
+		{"https://soundcloud.com/artist/likes", "", "", true},
+
+		// Bandcamp
+		{"https://artist.bandcamp.com/track/some-track", SourceBandcamp, "https://artist.bandcamp.com/track/some-track", false},
+		{"HTTPS://Artist.Bandcamp.com/track/some-track", SourceBandcamp, "https://artist.bandcamp.com/track/some-track", false},
+		{"https://artist.bandcamp.com/album/some-album", "", "", true},
+		{"https://bandcamp.com/track/some-track", "", "", true},
+
+		// others
+		{"https://evilyoutube.com/watch?v=dQw4w9WgXcQ", "", "", true},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", "", "", true},
+		{"ftp://youtube.com/watch?v=dQw4w9WgXcQ", "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			source, got, err := ParseSourceURL(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Fatalf("ParseSourceURL(%q) error = %v, want ErrInvalidRequest", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSourceURL(%q) unexpected error: %v", tt.input, err)
+			}
+			if source != tt.source || got != tt.want {
+				t.Fatalf("ParseSourceURL(%q) = (%s, %s), want (%s, %s)", tt.input, source, got, tt.source, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// How long a single playlist sync may take (every new track is queued separately)
+const playlistSyncTimeout = 30 * time.Minute
+
+// Only one playlist sync at a time (register request vs. cron)
+var playlistSyncMu sync.Mutex
+
+type PlaylistRequest struct {
+	SpotifyPlaylistID string `json:"spotify_playlist_id"`
+}
+
+// PlaylistRemoval is stored in the playlist "removals" field
+type PlaylistRemoval struct {
+	SpotifyTrackID string `json:"spotify_track_id"`
+	RemovedAt      string `json:"removed_at"`
+}
+
+// RegisterPlaylist stores the playlist (or returns the already registered one).
+// Its tracks are queued by SyncPlaylist.
+func RegisterPlaylist(ctx context.Context, app core.App, payload PlaylistRequest) (*core.Record, bool, error) {
+	if payload.SpotifyPlaylistID == "" {
+		return nil, false, fmt.Errorf("%w: spotify_playlist_id is required", ErrInvalidRequest)
+	}
+
+	existing, err := app.FindFirstRecordByData("playlists", "spotify_playlist_id", payload.SpotifyPlaylistID)
+	if err == nil {
+		return existing, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	meta, err := fetchSpotifyPlaylistMeta(ctx, payload.SpotifyPlaylistID)
+	if err != nil {
+		return nil, false, classifySpotifyError(err)
+	}
+
+	col, err := app.FindCollectionByNameOrId("playlists")
+	if err != nil {
+		return nil, false, err
+	}
+
+	playlist := core.NewRecord(col)
+	playlist.Set("spotify_playlist_id", meta.ID)
+	playlist.Set("name", meta.Name)
+	// snapshot_id stays empty until the first sync, so it always runs
+
+	if err := app.Save(playlist); err != nil {
+		return nil, false, err
+	}
+
+	return playlist, true, nil
+}
+
+// QueuePlaylist registers the playlist and syncs (queues) its tracks in the background
+func QueuePlaylist(ctx context.Context, app core.App, payload PlaylistRequest) (*core.Record, bool, error) {
+	playlist, created, err := RegisterPlaylist(ctx, app, payload)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Queueing a big playlist takes a while
+	go func() {
+		if err := SyncPlaylist(context.Background(), app, playlist.Id); err != nil {
+			log.Printf("Failed to sync playlist %s: %v", playlist.GetString("spotify_playlist_id"), err)
+		}
+	}()
+
+	return playlist, created, nil
+}
+
+// SyncAllPlaylists syncs every registered playlist (used by the cron)
+func SyncAllPlaylists(app core.App) {
+	playlists, err := app.FindAllRecords("playlists")
+	if err != nil {
+		log.Println("Failed to load playlists:", err)
+		return
+	}
+
+	for _, playlist := range playlists {
+		if err := SyncPlaylist(context.Background(), app, playlist.Id); err != nil {
+			log.Printf("Failed to sync playlist %s: %v", playlist.GetString("spotify_playlist_id"), err)
+		}
+	}
+}
+
+// SyncPlaylist re-reads the playlist when its snapshot_id changed, queues the
+// newly added tracks, records the removed ones and stores the new track order.
+// The snapshot_id only advances once every track was queued.
+func SyncPlaylist(ctx context.Context, app core.App, playlistID string) error {
+	playlistSyncMu.Lock()
+	defer playlistSyncMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, playlistSyncTimeout)
+	defer cancel()
+
+	playlist, err := app.FindRecordById("playlists", playlistID)
+	if err != nil {
+		return err
+	}
+	spotifyPlaylistID := playlist.GetString("spotify_playlist_id")
+
+	meta, err := fetchSpotifyPlaylistMeta(ctx, spotifyPlaylistID)
+	if err != nil {
+		return err
+	}
+
+	// Nothing changed since the last sync
+	if meta.SnapshotID == playlist.GetString("snapshot_id") {
+		return nil
+	}
+
+	spotifyTrackIDs, err := fetchSpotifyPlaylistTrackIDs(ctx, spotifyPlaylistID)
+	if err != nil {
+		return err
+	}
+
+	// Queue the tracks through the regular queue path, in playlist order
+	trackIDs := make([]string, 0, len(spotifyTrackIDs))
+	inPlaylist := make(map[string]struct{}, len(spotifyTrackIDs))
+	failed := 0
+	for _, id := range spotifyTrackIDs {
+		if _, ok := inPlaylist[id]; ok {
+			continue // the same track can be added to a playlist twice
+		}
+		inPlaylist[id] = struct{}{}
+
+		track, _, err := QueueTrack(ctx, app, DownloadRequest{
+			SpotifyTrackID: id,
+			Priority:       "prefetch",
+		})
+		if errors.Is(err, ErrUpstream) {
+			// keep the old snapshot so the next sync tries again
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed to queue track %s of playlist %s: %v", id, spotifyPlaylistID, err)
+			failed++
+			continue
+		}
+
+		trackIDs = append(trackIDs, track.Id)
+	}
+
+	// Record the removals
+	removals := []PlaylistRemoval{}
+	playlist.UnmarshalJSONField("removals", &removals)
+
+	previousTracks, err := app.FindRecordsByIds("tracks", playlist.GetStringSlice("tracks"))
+	if err != nil {
+		return err
+	}
+	now := types.NowDateTime().String()
+	for _, track := range previousTracks {
+		id := track.GetString("spotify_track_id")
+		if _, ok := inPlaylist[id]; !ok {
+			removals = append(removals, PlaylistRemoval{SpotifyTrackID: id, RemovedAt: now})
+		}
+	}
+
+	playlist.Set("name", meta.Name)
+	if failed == 0 {
+		playlist.Set("snapshot_id", meta.SnapshotID)
+	} else {
+		// keep the old snapshot so the next sync tries the failed tracks again
+		log.Printf("Failed to queue %d tracks of playlist %s, retrying them on the next sync", failed, spotifyPlaylistID)
+	}
+	playlist.Set("tracks", trackIDs)
+	playlist.Set("removals", removals)
+	playlist.Set("last_synced_at", types.NowDateTime())
+
+	return app.Save(playlist)
+}
+
+// ======================================================================
+//  SPOTIFY PLAYLIST FETCH
+// ======================================================================
+
+type spotifyPlaylistMeta struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	SnapshotID string `json:"snapshot_id"`
+}
+
+func fetchSpotifyPlaylistMeta(ctx context.Context, playlistID string) (*spotifyPlaylistMeta, error) {
+	query := url.Values{}
+	query.Set("fields", "id,name,snapshot_id")
+
+	var meta spotifyPlaylistMeta
+	endpoint := "/playlists/" + url.PathEscape(playlistID) + "?" + query.Encode()
+	if err := Spotify().get(ctx, endpoint, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// fetchSpotifyPlaylistTrackIDs pages through the playlist and returns its
+// track ids in order (local files and podcast episodes are skipped)
+func fetchSpotifyPlaylistTrackIDs(ctx context.Context, playlistID string) ([]string, error) {
+	query := url.Values{}
+	query.Set("limit", "100")
+	query.Set("fields", "next,items(is_local,track(id,type))")
+	next := "/playlists/" + url.PathEscape(playlistID) + "/tracks?" + query.Encode()
+
+	ids := []string{}
+	for next != "" {
+		var page struct {
+			Items []struct {
+				IsLocal bool `json:"is_local"`
+				Track   *struct {
+					ID   string `json:"id"`
+					Type string `json:"type"`
+				} `json:"track"`
+			} `json:"items"`
+			Next string `json:"next"`
+		}
+		if err := Spotify().get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			if item.IsLocal || item.Track == nil || item.Track.Type != "track" || item.Track.ID == "" {
+				continue
+			}
+			ids = append(ids, item.Track.ID)
+		}
+
+		next = page.Next
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Queue priorities - higher priorities are downloaded first,
+// tracks with the same priority are downloaded in FIFO order
+const (
+	PriorityPrefetch = 0
+	PriorityNormal   = 50
+	PriorityPlayNow  = 100
+)
+
+var ErrTrackNotQueued = errors.New("only queued tracks can be reprioritized")
+
+var priorityNames = map[string]int{
+	"prefetch": PriorityPrefetch,
+	"normal":   PriorityNormal,
+	"play_now": PriorityPlayNow,
+}
+
+// ParsePriority maps a priority name ("play_now", "normal", "prefetch") to its value.
+// An empty name is the normal priority.
+func ParsePriority(name string) (int, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+
+	priority, ok := priorityNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown priority %q", name)
+	}
+
+	return priority, nil
+}
+
+// SetTrackPriority bumps or demotes a queued track (trackRef: see FindTrack)
+func SetTrackPriority(app core.App, trackRef string, priority int) (*core.Record, error) {
+	track, err := FindTrack(app, trackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if track.GetString("download_status") != "queued" {
+		return nil, ErrTrackNotQueued
+	}
+
+	track.Set("priority", priority)
+	if err := app.Save(track); err != nil {
+		return nil, err
+	}
+
+	NotifyQueue()
+
+	return track, nil
+}
@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Download phases stored in the track "download_phase" field
+const (
+	PhaseSearching   = "searching"
+	PhaseDownloading = "downloading"
+	PhaseConverting  = "converting"
+	PhaseTagging     = "tagging"
+	PhaseUploading   = "uploading"
+)
+
+// Min time between two progress saves (phase changes are always saved)
+const progressSaveInterval = time.Second
+
+// Prefix of the lines printed through yt-dlp's --progress-template
+const progressLinePrefix = "[progress]"
+
+var percentRegex = regexp.MustCompile(`([\d.]+)%`)
+
+// progressReporter stores the current phase/percentage on the track, throttled
+// so realtime subscribers get a smooth progress bar without a save per yt-dlp line
+type progressReporter struct {
+	app   core.App
+	track *core.Record
+
+	mu        sync.Mutex
+	phase     string
+	percent   int
+	lastSaved time.Time
+
+	// unfinished output line
+	pending []byte
+}
+
+func newProgressReporter(app core.App, track *core.Record) *progressReporter {
+	return &progressReporter{app: app, track: track}
+}
+
+// Report updates the phase and percentage (0-100)
+func (r *progressReporter) Report(phase string, percent int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	phaseChanged := phase != r.phase
+	if !phaseChanged && (percent == r.percent || time.Since(r.lastSaved) < progressSaveInterval) {
+		return
+	}
+
+	r.phase = phase
+	r.percent = percent
+	r.lastSaved = time.Now()
+
+	// kept on the in-memory record too, the download saves it later on
+	r.track.Set("download_phase", phase)
+	r.track.Set("download_progress", percent)
+
+	if err := saveTrackProgress(r.app, r.track.Id, r.track.GetString("lease_owner"), phase, percent); err != nil {
+		log.Printf("Failed to save progress for track %s: %v", r.track.Id, err)
+	}
+}
+
+// saveTrackProgress writes only the progress columns, on a fresh copy of the
+// record - saving the (stale) in-memory one could overwrite a cancel or the
+// lease heartbeat. A fresh record (and not a raw UPDATE) is saved so the
+// realtime and SSE hooks still see the change.
+func saveTrackProgress(app core.App, trackID string, owner string, phase string, percent int) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		current, err := txApp.FindRecordById("tracks", trackID)
+		if err != nil {
+			return err
+		}
+
+		if owner != "" && current.GetString("lease_owner") != owner {
+			return fmt.Errorf("%w: track %s, owner %q", ErrLeaseLost, trackID, owner)
+		}
+
+		current.Set("download_phase", phase)
+		current.Set("download_progress", percent)
+
+		return txApp.Save(current)
+	})
+}
+
+// ======================================================================
+//  YT-DLP OUTPUT PARSING
+// ======================================================================
+
+// Write implements io.Writer so it can be used as the yt-dlp stdout.
+// The output is still forwarded to the process stdout.
+func (r *progressReporter) Write(p []byte) (int, error) {
+	os.Stdout.Write(p)
+
+	// lines can be split across writes - keep the unfinished one for later
+	r.pending = append(r.pending, p...)
+	for {
+		i := bytes.IndexByte(r.pending, '\n')
+		if i == -1 {
+			break
+		}
+
+		line := strings.TrimSpace(string(r.pending[:i]))
+		r.pending = r.pending[i+1:]
+
+		if phase, percent, ok := parseYTDLPLine(line); ok {
+			r.Report(phase, percent)
+		}
+	}
+
+	return len(p), nil
+}
+
+// parseYTDLPLine maps a single yt-dlp output line to a phase and percentage
+func parseYTDLPLine(line string) (phase string, percent int, ok bool) {
+	switch {
+	case strings.HasPrefix(line, progressLinePrefix):
+		m := percentRegex.FindStringSubmatch(line)
+		if m == nil {
+			return PhaseDownloading, 0, true
+		}
+		f, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return PhaseDownloading, 0, true
+		}
+		return PhaseDownloading, int(f), true
+	case strings.HasPrefix(line, "[ExtractAudio]"):
+		return PhaseConverting, 0, true
+	case strings.HasPrefix(line, "[youtube:search]"):
+		return PhaseSearching, 0, true
+	}
+
+	return "", 0, false
+}
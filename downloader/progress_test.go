@@ -0,0 +1,30 @@
+package downloader
+
+import "testing"
+
+func TestParseYTDLPLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		phase   string
+		percent int
+		ok      bool
+	}{
+		{"[progress]  42.7%", PhaseDownloading, 42, true},
+		{"[progress] 100.0%", PhaseDownloading, 100, true},
+		{"[progress]   0.0%", PhaseDownloading, 0, true},
+		{"[progress] N/A", PhaseDownloading, 0, true},
+		{"[ExtractAudio] Destination: downloads/abc.mp3", PhaseConverting, 0, true},
+		{"[youtube:search] Extracting URL: ytsearch10:artist title", PhaseSearching, 0, true},
+		{"[youtube] abcdefghijk: Downloading webpage", "", 0, false},
+		{"[download] Destination: downloads/abc.webm", "", 0, false},
+		{"", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		phase, percent, ok := parseYTDLPLine(tt.line)
+		if phase != tt.phase || percent != tt.percent || ok != tt.ok {
+			t.Errorf("parseYTDLPLine(%q) = (%q, %d, %v), want (%q, %d, %v)",
+				tt.line, phase, percent, ok, tt.phase, tt.percent, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/google/uuid"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/spf13/cobra"
+)
+
+// RefreshOptions controls which tracks RefreshMetadata touches
+type RefreshOptions struct {
+	// PocketBase filter expression ("" = all tracks)
+	Filter string
+	// Only report the changes, don't save anything
+	DryRun bool
+	// Re-write the tags of downloaded tracks even if nothing changed
+	Retag bool
+}
+
+// RefreshResult is the outcome of refreshing a single track
+type RefreshResult struct {
+	Track   *core.Record
+	Changed []string
+	Retag   bool
+	Err     error
+}
+
+// RefreshMetadata re-fetches the metadata of the matching tracks, updates the
+// changed fields and re-writes the ID3 tags of their stored files (the audio
+// itself isn't downloaded again). Tracks are processed one by one and a
+// failure doesn't stop the others.
+func RefreshMetadata(ctx context.Context, app core.App, opts RefreshOptions, report func(RefreshResult)) error {
+	tracks, err := app.FindRecordsByFilter("tracks", opts.Filter, "created", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		result := RefreshResult{Track: track}
+		result.Changed, result.Retag, result.Err = refreshTrack(ctx, app, track, opts)
+		report(result)
+	}
+
+	return nil
+}
+
+func refreshTrack(ctx context.Context, app core.App, track *core.Record, opts RefreshOptions) ([]string, bool, error) {
+	provider, err := GetMetadataProvider(track.GetString("provider"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	meta, err := provider.LookupID(lookupCtx, track.GetString("provider_id"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Diff against the stored values
+	before := make(map[string]any, len(trackMetadataFields))
+	for _, field := range trackMetadataFields {
+		before[field] = track.Get(field)
+	}
+
+	setTrackMetadata(track, meta)
+
+	// a dry run doesn't create artist/album records, so their relations aren't diffed
+	if !opts.DryRun {
+		if err := linkCatalog(app, track, meta); err != nil {
+			return nil, false, err
+		}
+	}
+
+	changed := []string{}
+	for _, field := range trackMetadataFields {
+		if !reflect.DeepEqual(before[field], track.Get(field)) {
+			changed = append(changed, field)
+		}
+	}
+
+	retag := (len(changed) > 0 || opts.Retag) &&
+		track.GetString("download_status") == "completed" &&
+		track.GetString("file") != ""
+
+	if opts.DryRun || (len(changed) == 0 && !retag) {
+		return changed, retag, nil
+	}
+
+	retaggedFile := ""
+	if retag {
+		var cleanup func()
+		retaggedFile, cleanup, err = retagStoredFile(app, track)
+		if err != nil {
+			return changed, retag, err
+		}
+		defer cleanup()
+	}
+
+	return changed, retag, saveRefreshedTrack(app, track, changed, retaggedFile)
+}
+
+// retagStoredFile writes the current track metadata to the ID3 tags of a copy
+// of the stored file. Returns the path of the copy and a func removing it.
+func retagStoredFile(app core.App, track *core.Record) (string, func(), error) {
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return "", nil, err
+	}
+	defer fsys.Close()
+
+	reader, err := fsys.GetReader(track.BaseFilesPath() + "/" + track.GetString("file"))
+	if err != nil {
+		return "", nil, fmt.Errorf("stored file read error: %w", err)
+	}
+	defer reader.Close()
+
+	downloadDir := "./downloads"
+	os.MkdirAll(downloadDir, os.ModePerm)
+
+	fileID := uuid.New().String()
+	tmpFile := filepath.Join(downloadDir, fmt.Sprintf("%s.mp3", fileID))
+	cleanup := func() { cleanupTempFiles(downloadDir, fileID) }
+
+	out, err := os.Create(tmpFile)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	_, err = io.Copy(out, reader)
+	out.Close()
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if err := writeID3Tags(track, tmpFile, fileID, downloadDir); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return tmpFile, cleanup, nil
+}
+
+// saveRefreshedTrack copies the changed metadata fields (and the retagged
+// file) onto a fresh copy of the record. The one loaded at the start of the
+// refresh can be stale by now - e.g. the worker moved the track on, and saving
+// it as is would overwrite the download status, lease and source fields.
+func saveRefreshedTrack(app core.App, track *core.Record, changed []string, retaggedFile string) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		current, err := txApp.FindRecordById("tracks", track.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range changed {
+			current.Set(field, track.Get(field))
+		}
+
+		if retaggedFile != "" {
+			if current.GetString("file") != track.GetString("file") {
+				// downloaded again meanwhile, the new file is tagged already
+				log.Printf("File of track %s was replaced meanwhile, not retagging it", track.Id)
+			} else {
+				file, err := filesystem.NewFileFromPath(retaggedFile)
+				if err != nil {
+					return err
+				}
+				current.Set("file", file)
+			}
+		}
+
+		return txApp.Save(current)
+	})
+}
+
+// ======================================================================
+//  CLI
+// ======================================================================
+
+// RegisterMetadataCommand adds the "metadata refresh" command to the root command
+func RegisterMetadataCommand(app core.App, rootCmd *cobra.Command) {
+	var opts RefreshOptions
+
+	refreshCmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Re-fetches the metadata of the stored tracks and re-writes their tags",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var refreshed, unchanged, failed int
+
+			err := RefreshMetadata(cmd.Context(), app, opts, func(r RefreshResult) {
+				name := r.Track.GetString("provider") + ":" + r.Track.GetString("provider_id")
+				switch {
+				case r.Err != nil:
+					failed++
+					log.Printf("%s: refresh failed: %v", name, r.Err)
+				case len(r.Changed) == 0 && !r.Retag:
+					unchanged++
+				default:
+					refreshed++
+					log.Printf("%s: changed %v (retagged: %v)", name, r.Changed, r.Retag)
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Refreshed %d tracks, %d unchanged, %d failed", refreshed, unchanged, failed)
+			return nil
+		},
+	}
+	refreshCmd.Flags().StringVar(&opts.Filter, "filter", "", `only refresh the tracks matching the filter, e.g. "album_id='xyz'"`)
+	refreshCmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "only print the changes")
+	refreshCmd.Flags().BoolVar(&opts.Retag, "retag", false, "re-write the tags of every downloaded track, even unchanged ones")
+
+	metadataCmd := &cobra.Command{
+		Use:   "metadata",
+		Short: "Manages the track metadata",
+	}
+	metadataCmd.AddCommand(refreshCmd)
+
+	rootCmd.AddCommand(metadataCmd)
+}
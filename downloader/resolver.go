@@ -0,0 +1,158 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Spotify resource types that can be queued
+const (
+	SpotifyTypeTrack    = "track"
+	SpotifyTypeAlbum    = "album"
+	SpotifyTypePlaylist = "playlist"
+	SpotifyTypeArtist   = "artist"
+)
+
+// SpotifyRef is a normalized reference to a Spotify resource
+type SpotifyRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// URI returns the canonical "spotify:<type>:<id>" form
+func (r SpotifyRef) URI() string {
+	return "spotify:" + r.Type + ":" + r.ID
+}
+
+func isSpotifyType(t string) bool {
+	switch t {
+	case SpotifyTypeTrack, SpotifyTypeAlbum, SpotifyTypePlaylist, SpotifyTypeArtist:
+		return true
+	}
+	return false
+}
+
+// ParseSpotifyRef accepts:
+//   - spotify URIs: "spotify:track:<id>" (and the legacy "spotify:user:<user>:playlist:<id>")
+//   - open.spotify.com URLs: "https://open.spotify.com/track/<id>?si=...",
+//     including the "/intl-xx/" and "/embed/" variants
+//   - bare ids, which are treated as track ids
+func ParseSpotifyRef(input string) (SpotifyRef, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return SpotifyRef{}, fmt.Errorf("%w: url is required", ErrInvalidRequest)
+	}
+
+	var ref SpotifyRef
+
+	switch {
+	case strings.HasPrefix(input, "spotify:"):
+		parts := strings.Split(input, ":")
+		if len(parts) >= 3 {
+			ref = SpotifyRef{Type: parts[len(parts)-2], ID: parts[len(parts)-1]}
+		}
+	case strings.Contains(strings.ToLower(input), "spotify.com/"):
+		if !strings.Contains(input, "://") {
+			input = "https://" + input
+		}
+
+		u, err := url.Parse(input)
+		if err != nil {
+			return SpotifyRef{}, fmt.Errorf("%w: invalid url: %v", ErrInvalidRequest, err)
+		}
+		if host := strings.ToLower(u.Hostname()); host != "spotify.com" && !strings.HasSuffix(host, ".spotify.com") {
+			return SpotifyRef{}, fmt.Errorf("%w: not a spotify url", ErrInvalidRequest)
+		}
+
+		// find the "<type>/<id>" pair, skipping prefixes like "intl-de", "embed" or "user/<name>"
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		for i := 0; i+1 < len(segments); i++ {
+			if isSpotifyType(segments[i]) {
+				ref = SpotifyRef{Type: segments[i], ID: segments[i+1]}
+			}
+		}
+	default:
+		ref = SpotifyRef{Type: SpotifyTypeTrack, ID: input}
+	}
+
+	if !isSpotifyType(ref.Type) {
+		return SpotifyRef{}, fmt.Errorf("%w: unsupported spotify link %q", ErrInvalidRequest, input)
+	}
+	if !spotifyIDRegex.MatchString(ref.ID) {
+		return SpotifyRef{}, fmt.Errorf("%w: invalid spotify id %q", ErrInvalidRequest, ref.ID)
+	}
+
+	return ref, nil
+}
+
+// ======================================================================
+//  DISPATCH
+// ======================================================================
+
+type QueueRequest struct {
+	// Spotify URL, URI or bare track id
+	URL string `json:"url"`
+	// "play_now", "normal" or "prefetch" (defaults depend on the type)
+	Priority string `json:"priority"`
+	// Only used for artists, see ArtistQueueRequest
+	IncludeGroups []string `json:"include_groups"`
+}
+
+type QueueResponse struct {
+	SpotifyRef
+	// Whether something new was created (a track or a playlist)
+	Created bool `json:"created"`
+	// *core.Record for tracks and playlists, *AlbumQueueResult or *ArtistQueueResult otherwise
+	Result any `json:"result"`
+}
+
+// Queue resolves the Spotify link and dispatches it to the matching enqueue flow
+func Queue(ctx context.Context, app core.App, payload QueueRequest) (*QueueResponse, error) {
+	ref, err := ParseSpotifyRef(payload.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &QueueResponse{SpotifyRef: ref}
+
+	switch ref.Type {
+	case SpotifyTypeTrack:
+		record, status, err := QueueTrack(ctx, app, DownloadRequest{SpotifyTrackID: ref.ID, Priority: payload.Priority})
+		if err != nil {
+			return nil, err
+		}
+		response.Created = status == QueueCreated
+		response.Result = record
+	case SpotifyTypeAlbum:
+		result, err := QueueAlbum(ctx, app, AlbumQueueRequest{SpotifyAlbumID: ref.ID, Priority: payload.Priority})
+		if err != nil {
+			return nil, err
+		}
+		response.Created = result.Created > 0
+		response.Result = result
+	case SpotifyTypePlaylist:
+		playlist, created, err := QueuePlaylist(ctx, app, PlaylistRequest{SpotifyPlaylistID: ref.ID})
+		if err != nil {
+			return nil, err
+		}
+		response.Created = created
+		response.Result = playlist
+	case SpotifyTypeArtist:
+		result, err := QueueArtist(ctx, app, ArtistQueueRequest{
+			SpotifyArtistID: ref.ID,
+			IncludeGroups:   payload.IncludeGroups,
+			Priority:        payload.Priority,
+		})
+		if err != nil {
+			return nil, err
+		}
+		response.Created = result.New > 0
+		response.Result = result
+	}
+
+	return response, nil
+}
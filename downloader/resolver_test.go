@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSpotifyRef(t *testing.T) {
+	const id = "4uLU6hMCjMI75M1A2tKUQC"
+
+	tests := []struct {
+		input   string
+		want    SpotifyRef
+		wantErr bool
+	}{
+		// URIs
+		{"spotify:track:" + id, SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"spotify:album:" + id, SpotifyRef{SpotifyTypeAlbum, id}, false},
+		{"spotify:user:someone:playlist:" + id, SpotifyRef{SpotifyTypePlaylist, id}, false},
+		{"spotify:show:" + id, SpotifyRef{}, true},
+		{"spotify:track", SpotifyRef{}, true},
+
+		// URLs
+		{"https://open.spotify.com/track/" + id + "?si=abc", SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"https://open.spotify.com/intl-de/album/" + id, SpotifyRef{SpotifyTypeAlbum, id}, false},
+		{"https://open.spotify.com/embed/playlist/" + id, SpotifyRef{SpotifyTypePlaylist, id}, false},
+		{"https://open.spotify.com/user/someone/playlist/" + id, SpotifyRef{SpotifyTypePlaylist, id}, false},
+		{"open.spotify.com/artist/" + id, SpotifyRef{SpotifyTypeArtist, id}, false},
+		{"https://spotify.com/track/" + id, SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"https://OPEN.SPOTIFY.COM/track/" + id, SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"https://open.spotify.com/episode/" + id, SpotifyRef{}, true},
+		{"https://open.spotify.com/track/tooshort", SpotifyRef{}, true},
+
+		// lookalike hosts
+		{"https://evilspotify.com/track/" + id, SpotifyRef{}, true},
+		{"https://open.spotify.com.evil.com/track/" + id, SpotifyRef{}, true},
+		{"https://evil.com/open.spotify.com/track/" + id, SpotifyRef{}, true},
+
+		// bare ids
+		{id, SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"  " + id + "  ", SpotifyRef{SpotifyTypeTrack, id}, false},
+		{"not-an-id", SpotifyRef{}, true},
+		{"", SpotifyRef{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSpotifyRef(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Fatalf("ParseSpotifyRef(%q) error = %v, want ErrInvalidRequest", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSpotifyRef(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseSpotifyRef(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// No video inside the duration window was found - retrying won't change that
+var ErrNoMatchingVideo = errors.New("no matching video found")
+
+// PermanentError marks a download error that should not be retried
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err so IsPermanent reports true for it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// SpotifyError is returned for non 2xx responses from the Spotify API
+type SpotifyError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SpotifyError) Error() string {
+	return fmt.Sprintf("spotify error %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports if the request is worth retrying (rate limits and server errors)
+func (e *SpotifyError) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// IsPermanent reports whether err should put a track straight into "failed".
+// Everything we can't classify (network errors, yt-dlp exit codes...) is
+// treated as transient.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	if errors.As(err, &permanentErr) {
+		return true
+	}
+
+	var spotifyErr *SpotifyError
+	if errors.As(err, &spotifyErr) {
+		return !spotifyErr.Temporary()
+	}
+
+	return false
+}
+
+// ======================================================================
+//  RETRY POLICY
+// ======================================================================
+
+type RetryPolicy struct {
+	// After this many attempts a track is moved to "dead"
+	MaxAttempts int
+	// Delay before the first retry, doubled on every following attempt
+	BaseDelay time.Duration
+	// Upper bound for the delay
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Minute,
+	MaxDelay:    time.Hour,
+}
+
+// Backoff returns the delay before the next attempt, given how many attempts were already made
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Exhausted reports if no more attempts are allowed
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"plain error", errors.New("yt-dlp failed: exit status 1"), false},
+		{"context canceled", context.Canceled, false},
+		{"permanent", Permanent(ErrNoMatchingVideo), true},
+		{"wrapped permanent", fmt.Errorf("download: %w", Permanent(ErrNoMatchingVideo)), true},
+		{"spotify 404", &SpotifyError{StatusCode: 404}, true},
+		{"spotify 400", &SpotifyError{StatusCode: 400}, true},
+		{"spotify 429", &SpotifyError{StatusCode: 429}, false},
+		{"spotify 500", &SpotifyError{StatusCode: 500}, false},
+		{"spotify 503", fmt.Errorf("metadata: %w", &SpotifyError{StatusCode: 503}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermanentNil(t *testing.T) {
+	if err := Permanent(nil); err != nil {
+		t.Fatalf("Permanent(nil) = %v, want nil", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyExhausted(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	tests := []struct {
+		attempts int
+		want     bool
+	}{
+		{0, false},
+		{2, false},
+		{3, true},
+		{4, true},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Exhausted(tt.attempts); got != tt.want {
+			t.Errorf("Exhausted(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audio sources
+const (
+	SourceYouTube      = "youtube"
+	SourceYouTubeMusic = "youtube_music"
+	SourceSoundCloud   = "soundcloud"
+	SourceBandcamp     = "bandcamp"
+)
+
+// Sources tried when AUDIO_SOURCES isn't set
+var defaultAudioSourceOrder = []string{SourceYouTube, SourceYouTubeMusic, SourceSoundCloud, SourceBandcamp}
+
+// AudioSource finds and downloads the audio of a track at a single service
+type AudioSource interface {
+	// Name is stored on the candidates
+	Name() string
+	// Search returns the (unscored) candidates for the search query
+	Search(ctx context.Context, query string) ([]Candidate, error)
+	// Fetch downloads the candidate as an mp3 to dest, the tool output is written to out
+	Fetch(ctx context.Context, c Candidate, dest string, out io.Writer) error
+	// MatchURL returns the canonical form of u if it points to a single
+	// track/video of this source (used for pinning the source of a track)
+	MatchURL(u *url.URL) (string, bool)
+}
+
+var (
+	audioSourcesMu sync.RWMutex
+	audioSources   = map[string]AudioSource{}
+)
+
+func init() {
+	RegisterAudioSource(&ytdlpSource{
+		name: SourceYouTube,
+		searchTarget: func(q string) string {
+			return fmt.Sprintf("ytsearch%d:%s", candidateSearchSize, q)
+		},
+		matchURL: matchYouTubeURL,
+	})
+	RegisterAudioSource(&ytdlpSource{
+		name: SourceYouTubeMusic,
+		searchTarget: func(q string) string {
+			return "https://music.youtube.com/search?q=" + url.QueryEscape(q) + "#songs"
+		},
+		matchURL: matchYouTubeMusicURL,
+	})
+	RegisterAudioSource(&ytdlpSource{
+		name: SourceSoundCloud,
+		searchTarget: func(q string) string {
+			return fmt.Sprintf("scsearch%d:%s", candidateSearchSize, q)
+		},
+		matchURL: matchSoundCloudURL,
+	})
+	RegisterAudioSource(newBandcampSource())
+}
+
+// RegisterAudioSource adds (or replaces) a source under its name
+func RegisterAudioSource(s AudioSource) {
+	audioSourcesMu.Lock()
+	defer audioSourcesMu.Unlock()
+
+	audioSources[s.Name()] = s
+}
+
+// GetAudioSource returns the source registered under the name
+func GetAudioSource(name string) (AudioSource, error) {
+	audioSourcesMu.RLock()
+	defer audioSourcesMu.RUnlock()
+
+	s, ok := audioSources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown audio source %q", name)
+	}
+
+	return s, nil
+}
+
+// AudioSources returns the sources in their fallback order, configured with
+// the AUDIO_SOURCES env var (comma separated names, e.g. "youtube,soundcloud")
+func AudioSources() []AudioSource {
+	order := defaultAudioSourceOrder
+	if v := os.Getenv("AUDIO_SOURCES"); v != "" {
+		order = strings.Split(v, ",")
+	}
+
+	sources := []AudioSource{}
+	for _, name := range order {
+		s, err := GetAudioSource(strings.TrimSpace(name))
+		if err != nil {
+			continue
+		}
+		sources = append(sources, s)
+	}
+
+	return sources
+}
+
+// registeredAudioSources returns every registered source (sorted by name),
+// including the ones left out of the fallback order
+func registeredAudioSources() []AudioSource {
+	audioSourcesMu.RLock()
+	defer audioSourcesMu.RUnlock()
+
+	sources := make([]AudioSource, 0, len(audioSources))
+	for _, s := range audioSources {
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name() < sources[j].Name()
+	})
+
+	return sources
+}
+
+// ======================================================================
+//  YT-DLP SOURCES (YouTube, YouTube Music, SoundCloud)
+// ======================================================================
+
+// ytdlpSource searches and downloads through one of yt-dlp's extractors
+type ytdlpSource struct {
+	name string
+	// builds the yt-dlp search target ("ytsearch10:<query>", a search URL...)
+	searchTarget func(query string) string
+	// see AudioSource.MatchURL
+	matchURL func(u *url.URL) (string, bool)
+}
+
+func (s *ytdlpSource) Name() string {
+	return s.name
+}
+
+func (s *ytdlpSource) Search(ctx context.Context, query string) ([]Candidate, error) {
+	candidates, err := ytdlpSearch(ctx, s.searchTarget(query))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		candidates[i].Source = s.name
+	}
+
+	return candidates, nil
+}
+
+func (s *ytdlpSource) Fetch(ctx context.Context, c Candidate, dest string, out io.Writer) error {
+	return ytdlpFetch(ctx, c.URL, dest, out)
+}
+
+func (s *ytdlpSource) MatchURL(u *url.URL) (string, bool) {
+	return s.matchURL(u)
+}
+
+var youtubeVideoIDRegex = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+
+// youtubeVideoID returns the video id of a youtube.com/watch?v=<id>,
+// youtube.com/shorts/<id>, youtube.com/embed/<id> or youtu.be/<id> URL
+func youtubeVideoID(u *url.URL) string {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	id := ""
+	switch {
+	case urlHost(u) == "youtu.be":
+		id = segments[0]
+	case segments[0] == "watch":
+		id = u.Query().Get("v")
+	case len(segments) == 2 && (segments[0] == "shorts" || segments[0] == "embed"):
+		id = segments[1]
+	}
+
+	if !youtubeVideoIDRegex.MatchString(id) {
+		return ""
+	}
+
+	return id
+}
+
+// matchYouTubeURL accepts youtube.com and youtu.be video URLs
+func matchYouTubeURL(u *url.URL) (string, bool) {
+	switch urlHost(u) {
+	case "youtube.com", "m.youtube.com", "youtu.be":
+	default:
+		return "", false
+	}
+
+	id := youtubeVideoID(u)
+	if id == "" {
+		return "", false
+	}
+
+	return "https://www.youtube.com/watch?v=" + id, true
+}
+
+// matchYouTubeMusicURL accepts music.youtube.com/watch?v=<id>
+func matchYouTubeMusicURL(u *url.URL) (string, bool) {
+	if urlHost(u) != "music.youtube.com" {
+		return "", false
+	}
+
+	id := youtubeVideoID(u)
+	if id == "" {
+		return "", false
+	}
+
+	return "https://music.youtube.com/watch?v=" + id, true
+}
+
+// SoundCloud paths of a user that aren't tracks (soundcloud.com/<user>/<page>)
+var soundCloudUserPages = map[string]struct{}{
+	"sets": {}, "tracks": {}, "albums": {}, "popular-tracks": {}, "reposts": {},
+	"likes": {}, "followers": {}, "following": {}, "comments": {},
+}
+
+// matchSoundCloudURL accepts soundcloud.com/<user>/<track>
+func matchSoundCloudURL(u *url.URL) (string, bool) {
+	switch urlHost(u) {
+	case "soundcloud.com", "m.soundcloud.com":
+	default:
+		return "", false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) != 2 || segments[0] == "" || segments[1] == "" {
+		return "", false
+	}
+	if _, ok := soundCloudUserPages[segments[1]]; ok {
+		return "", false
+	}
+
+	return "https://soundcloud.com/" + segments[0] + "/" + segments[1], true
+}
+
+// urlHost returns the lowercased host of u without the "www." prefix
+func urlHost(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// ytdlpFetch downloads the audio of a single (already picked) URL
+func ytdlpFetch(ctx context.Context, sourceURL string, dest string, out io.Writer) error {
+	cmd := createYTDLPCommand(ctx, sourceURL, dest)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		// The download was cancelled (yt-dlp got killed)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	if _, err := os.Stat(dest); err != nil {
+		return fmt.Errorf("yt-dlp didn't write the output file: %w", err)
+	}
+
+	return nil
+}
+
+// ======================================================================
+//  BANDCAMP
+// ======================================================================
+
+// Bandcamp's (undocumented) search autocomplete API, yt-dlp can't search Bandcamp
+const bandcampSearchURL = "https://bandcamp.com/api/bcsearch_public_api/1/autocomplete_elastic"
+
+// Timeout of a single Bandcamp search request
+const bandcampRequestTimeout = 15 * time.Second
+
+// bandcampSource searches through the Bandcamp API and downloads with yt-dlp
+type bandcampSource struct {
+	searchURL  string
+	httpClient *http.Client
+}
+
+func newBandcampSource() *bandcampSource {
+	return &bandcampSource{
+		searchURL:  bandcampSearchURL,
+		httpClient: &http.Client{Timeout: bandcampRequestTimeout},
+	}
+}
+
+func (s *bandcampSource) Name() string {
+	return SourceBandcamp
+}
+
+func (s *bandcampSource) Search(ctx context.Context, query string) ([]Candidate, error) {
+	body, _ := json.Marshal(map[string]any{
+		"search_text":   query,
+		"search_filter": "t", // tracks only
+		"full_page":     false,
+		"fan_id":        nil,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.searchURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bandcamp search failed with status %d", resp.StatusCode)
+	}
+
+	var data struct {
+		Auto struct {
+			Results []struct {
+				Type     string `json:"type"`
+				ID       int64  `json:"id"`
+				Name     string `json:"name"`
+				BandName string `json:"band_name"`
+				URL      string `json:"item_url_path"`
+			} `json:"results"`
+		} `json:"auto"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("bandcamp response decode error: %w", err)
+	}
+
+	// the results don't include the duration, see probeMissingDurations
+	candidates := []Candidate{}
+	for _, r := range data.Auto.Results {
+		if r.Type != "t" || r.URL == "" {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			Source:  SourceBandcamp,
+			ID:      fmt.Sprint(r.ID),
+			URL:     r.URL,
+			Title:   r.Name,
+			Channel: r.BandName,
+		})
+		if len(candidates) == candidateSearchSize {
+			break
+		}
+	}
+
+	return candidates, nil
+}
+
+func (s *bandcampSource) Fetch(ctx context.Context, c Candidate, dest string, out io.Writer) error {
+	return ytdlpFetch(ctx, c.URL, dest, out)
+}
+
+// MatchURL accepts <artist>.bandcamp.com/track/<track> (custom artist domains
+// can't be told apart from any other site)
+func (s *bandcampSource) MatchURL(u *url.URL) (string, bool) {
+	host := urlHost(u)
+	if !strings.HasSuffix(host, ".bandcamp.com") {
+		return "", false
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) != 2 || segments[0] != "track" || segments[1] == "" {
+		return "", false
+	}
+
+	return "https://" + host + "/track/" + segments[1], true
+}
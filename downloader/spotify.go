@@ -0,0 +1,506 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSpotifyBaseURL     = "https://api.spotify.com/v1"
+	DefaultSpotifyAccountsURL = "https://accounts.spotify.com"
+)
+
+// Refresh the access token this long before Spotify expires it
+const spotifyTokenRefreshMargin = time.Minute
+
+// Timeout of a single HTTP request to Spotify
+const spotifyRequestTimeout = 15 * time.Second
+
+// Rate limited (429), failed (5xx) and timed out requests are retried this many times
+const spotifyMaxRetries = 3
+
+// Backoff for retries without a Retry-After header (doubled on every retry)
+const spotifyRetryBaseDelay = time.Second
+
+// Don't wait longer than this for a Retry-After - fail the request instead
+const spotifyMaxRetryAfter = 30 * time.Second
+
+// Max ids per Spotify multi-get request
+const spotifyTracksBatchSize = 50
+
+// The client credentials are missing or were rejected by Spotify
+var ErrSpotifyAuth = errors.New("spotify auth failed")
+
+// SpotifyClient talks to the Spotify Web API with the client credentials
+// flow. The access token is cached and shared by every request.
+type SpotifyClient struct {
+	clientID     string
+	clientSecret string
+
+	// Overridable, e.g. to point the client at a local httptest server
+	BaseURL     string
+	AccountsURL string
+	HTTPClient  *http.Client
+	MaxRetries  int
+	// Backoff for retries without a Retry-After header (doubled on every retry)
+	RetryBaseDelay time.Duration
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewSpotifyClient(clientID, clientSecret string) *SpotifyClient {
+	return &SpotifyClient{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		BaseURL:      DefaultSpotifyBaseURL,
+		AccountsURL:  DefaultSpotifyAccountsURL,
+		HTTPClient:   &http.Client{Timeout: spotifyRequestTimeout},
+		MaxRetries:   spotifyMaxRetries,
+
+		RetryBaseDelay: spotifyRetryBaseDelay,
+	}
+}
+
+var (
+	defaultSpotifyClientMu sync.Mutex
+	defaultSpotifyClient   *SpotifyClient
+)
+
+// Spotify returns the shared client, configured from the SPOTIFY_CLIENT_ID and
+// SPOTIFY_CLIENT_SECRET env vars (and optionally SPOTIFY_API_BASE_URL and
+// SPOTIFY_ACCOUNTS_URL)
+func Spotify() *SpotifyClient {
+	defaultSpotifyClientMu.Lock()
+	defer defaultSpotifyClientMu.Unlock()
+
+	if defaultSpotifyClient == nil {
+		defaultSpotifyClient = NewSpotifyClient(
+			os.Getenv("SPOTIFY_CLIENT_ID"),
+			os.Getenv("SPOTIFY_CLIENT_SECRET"),
+		)
+		if v := os.Getenv("SPOTIFY_API_BASE_URL"); v != "" {
+			defaultSpotifyClient.BaseURL = v
+		}
+		if v := os.Getenv("SPOTIFY_ACCOUNTS_URL"); v != "" {
+			defaultSpotifyClient.AccountsURL = v
+		}
+	}
+
+	return defaultSpotifyClient
+}
+
+// SetSpotify replaces the shared client. Requests that already started keep
+// using the previous one.
+func SetSpotify(c *SpotifyClient) {
+	defaultSpotifyClientMu.Lock()
+	defer defaultSpotifyClientMu.Unlock()
+
+	defaultSpotifyClient = c
+}
+
+// ======================================================================
+//  ACCESS TOKEN
+// ======================================================================
+
+// Token returns the cached access token, refreshing it when it is (about to be) expired
+func (c *SpotifyClient) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expiresAt.Add(-spotifyTokenRefreshMargin)) {
+		return c.token, nil
+	}
+
+	token, expiresIn, err := c.requestToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.token = token
+	c.expiresAt = time.Now().Add(expiresIn)
+
+	return c.token, nil
+}
+
+// invalidateToken drops the cached token (e.g. after Spotify rejected it)
+func (c *SpotifyClient) invalidateToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// requestToken fetches a new access token. Rate limited (429) and failed
+// (5xx) token requests and network errors are retried like in get.
+func (c *SpotifyClient) requestToken(ctx context.Context) (string, time.Duration, error) {
+	if c.clientID == "" || c.clientSecret == "" {
+		return "", 0, fmt.Errorf("%w: missing spotify client id/secret env vars", ErrSpotifyAuth)
+	}
+
+	for retry := 0; ; retry++ {
+		resp, err := c.doTokenRequest(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", 0, ctx.Err()
+			}
+			if ok, waitErr := c.waitRetry(ctx, retry, ""); waitErr != nil {
+				return "", 0, waitErr
+			} else if !ok {
+				return "", 0, err
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			defer resp.Body.Close()
+
+			var data struct {
+				AccessToken string `json:"access_token"`
+				ExpiresIn   int    `json:"expires_in"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+				return "", 0, fmt.Errorf("spotify token decode error: %w", err)
+			}
+			if data.AccessToken == "" {
+				return "", 0, fmt.Errorf("%w: empty access token", ErrSpotifyAuth)
+			}
+
+			return data.AccessToken, time.Duration(data.ExpiresIn) * time.Second, nil
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		spotifyErr := &SpotifyError{StatusCode: resp.StatusCode, Body: string(body)}
+
+		if !spotifyErr.Temporary() {
+			return "", 0, fmt.Errorf("%w: %w", ErrSpotifyAuth, spotifyErr)
+		}
+		if ok, err := c.waitRetry(ctx, retry, resp.Header.Get("Retry-After")); err != nil {
+			return "", 0, err
+		} else if !ok {
+			return "", 0, spotifyErr
+		}
+	}
+}
+
+func (c *SpotifyClient) doTokenRequest(ctx context.Context) (*http.Response, error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(c.clientID + ":" + c.clientSecret))
+
+	reqData := url.Values{}
+	reqData.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		strings.TrimRight(c.AccountsURL, "/")+"/api/token",
+		strings.NewReader(reqData.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Basic "+auth)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.HTTPClient.Do(req)
+}
+
+// ======================================================================
+//  REQUESTS
+// ======================================================================
+
+// get performs an authorized GET request and decodes the JSON response into out.
+// The endpoint is either a path relative to BaseURL ("/tracks/<id>") or a full
+// URL (e.g. the "next" link of a paged response).
+//
+// A rejected (401) token is refreshed and the request retried once, rate
+// limited (429) and failed (5xx) requests are retried with a backoff that
+// honors the Retry-After header. Network errors and timeouts are retried
+// with the same backoff.
+func (c *SpotifyClient) get(ctx context.Context, endpoint string, out any) error {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = strings.TrimRight(c.BaseURL, "/") + endpoint
+	}
+
+	refreshedToken := false
+	for retry := 0; ; retry++ {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.doGet(ctx, token, endpoint)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ok, waitErr := c.waitRetry(ctx, retry, ""); waitErr != nil {
+				return waitErr
+			} else if !ok {
+				return err
+			}
+			continue
+		}
+
+		if resp.StatusCode < 400 {
+			defer resp.Body.Close()
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("spotify response decode error: %w", err)
+			}
+			return nil
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		spotifyErr := &SpotifyError{StatusCode: resp.StatusCode, Body: string(body)}
+
+		if resp.StatusCode == http.StatusUnauthorized && !refreshedToken {
+			refreshedToken = true
+			c.invalidateToken(token)
+			continue
+		}
+
+		if !spotifyErr.Temporary() {
+			return spotifyErr
+		}
+		if ok, err := c.waitRetry(ctx, retry, resp.Header.Get("Retry-After")); err != nil {
+			return err
+		} else if !ok {
+			return spotifyErr
+		}
+	}
+}
+
+// waitRetry waits before the next retry of a failed request, honoring the
+// Retry-After header (if any). It returns false without waiting when the
+// retries are used up or Retry-After asks for too long a wait.
+func (c *SpotifyClient) waitRetry(ctx context.Context, retry int, retryAfterHeader string) (bool, error) {
+	if retry >= c.MaxRetries {
+		return false, nil
+	}
+
+	delay := retryAfter(retryAfterHeader, c.RetryBaseDelay<<retry)
+	if delay > spotifyMaxRetryAfter {
+		return false, nil
+	}
+
+	return true, sleepCtx(ctx, delay)
+}
+
+// sleepCtx waits for the delay, or returns early when the context is done
+func sleepCtx(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+func (c *SpotifyClient) doGet(ctx context.Context, token string, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return c.HTTPClient.Do(req)
+}
+
+// retryAfter parses a Retry-After header (in seconds), falling back to the given delay
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+// ======================================================================
+//  TRACKS
+// ======================================================================
+
+func fetchSpotifyMetadata(ctx context.Context, trackID string) (*SpotifyTrack, error) {
+	var track SpotifyTrack
+	if err := Spotify().get(ctx, "/tracks/"+url.PathEscape(trackID), &track); err != nil {
+		return nil, err
+	}
+
+	return &track, nil
+}
+
+// fetchSpotifyTracks fetches many tracks through the multi-get endpoint.
+// Unknown ids are missing from the returned map.
+func fetchSpotifyTracks(ctx context.Context, trackIDs []string) (map[string]*SpotifyTrack, error) {
+	tracks := make(map[string]*SpotifyTrack, len(trackIDs))
+	for start := 0; start < len(trackIDs); start += spotifyTracksBatchSize {
+		end := min(start+spotifyTracksBatchSize, len(trackIDs))
+
+		query := url.Values{}
+		query.Set("ids", strings.Join(trackIDs[start:end], ","))
+
+		// unknown ids come back as null
+		var data struct {
+			Tracks []*SpotifyTrack `json:"tracks"`
+		}
+		if err := Spotify().get(ctx, "/tracks?"+query.Encode(), &data); err != nil {
+			return nil, err
+		}
+
+		for _, t := range data.Tracks {
+			if t != nil {
+				tracks[t.ID] = t
+			}
+		}
+	}
+
+	return tracks, nil
+}
+
+// ======================================================================
+//  ARTISTS
+// ======================================================================
+
+// fetchSpotifyArtistImages returns the (largest) image url of every artist
+// that has one, fetched through the multi-get endpoint
+func fetchSpotifyArtistImages(ctx context.Context, artistIDs []string) (map[string]string, error) {
+	images := make(map[string]string, len(artistIDs))
+	for start := 0; start < len(artistIDs); start += spotifyTracksBatchSize {
+		end := min(start+spotifyTracksBatchSize, len(artistIDs))
+
+		query := url.Values{}
+		query.Set("ids", strings.Join(artistIDs[start:end], ","))
+
+		// unknown ids come back as null
+		var data struct {
+			Artists []*struct {
+				ID     string         `json:"id"`
+				Images []SpotifyImage `json:"images"`
+			} `json:"artists"`
+		}
+		if err := Spotify().get(ctx, "/artists?"+query.Encode(), &data); err != nil {
+			return nil, err
+		}
+
+		for _, a := range data.Artists {
+			if a != nil && len(a.Images) > 0 {
+				images[a.ID] = a.Images[0].URL
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// ======================================================================
+//  METADATA
+// ======================================================================
+
+// Metadata converts the Spotify track to the provider-neutral metadata
+func (t *SpotifyTrack) Metadata() *TrackMetadata {
+	meta := &TrackMetadata{
+		Provider:    ProviderSpotify,
+		ProviderID:  t.ID,
+		SpotifyID:   t.ID,
+		Name:        t.Name,
+		DurationMs:  t.DurationMs,
+		TrackNumber: t.TrackNumber,
+		DiscNumber:  t.DiscNumber,
+		ISRC:        t.ExternalIDs.ISRC,
+		Explicit:    t.Explicit,
+		Popularity:  t.Popularity,
+		Album: AlbumMetadata{
+			ID:          t.Album.ID,
+			Name:        t.Album.Name,
+			ReleaseDate: t.Album.ReleaseDate,
+			Type:        t.Album.AlbumType,
+			TotalTracks: t.Album.TotalTracks,
+		},
+	}
+	for _, a := range t.Artists {
+		meta.Artists = append(meta.Artists, ArtistMetadata{ID: a.ID, Name: a.Name})
+	}
+	for _, a := range t.Album.Artists {
+		meta.Album.Artists = append(meta.Album.Artists, ArtistMetadata{ID: a.ID, Name: a.Name})
+	}
+	if len(t.Album.Images) > 0 {
+		meta.Album.CoverURL = t.Album.Images[0].URL
+	}
+
+	return meta
+}
+
+// ======================================================================
+//  METADATA PROVIDER
+// ======================================================================
+
+// SpotifyProvider is the MetadataProvider backed by the shared Spotify client
+type SpotifyProvider struct{}
+
+func (SpotifyProvider) Name() string {
+	return ProviderSpotify
+}
+
+func (SpotifyProvider) LookupID(ctx context.Context, id string) (*TrackMetadata, error) {
+	track, err := fetchSpotifyMetadata(ctx, id)
+	if err != nil {
+		return nil, classifySpotifyError(err)
+	}
+
+	return track.Metadata(), nil
+}
+
+func (p SpotifyProvider) LookupISRC(ctx context.Context, isrc string) (*TrackMetadata, error) {
+	tracks, err := searchSpotifyTracks(ctx, "isrc:"+isrc, 1)
+	if err != nil {
+		return nil, classifySpotifyError(err)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w: no track with isrc %s", ErrSpotifyNotFound, isrc)
+	}
+
+	return tracks[0].Metadata(), nil
+}
+
+func (SpotifyProvider) Search(ctx context.Context, query string, limit int) ([]*TrackMetadata, error) {
+	tracks, err := searchSpotifyTracks(ctx, query, limit)
+	if err != nil {
+		return nil, classifySpotifyError(err)
+	}
+
+	results := make([]*TrackMetadata, 0, len(tracks))
+	for _, t := range tracks {
+		results = append(results, t.Metadata())
+	}
+
+	return results, nil
+}
+
+func searchSpotifyTracks(ctx context.Context, q string, limit int) ([]*SpotifyTrack, error) {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("type", "track")
+	query.Set("limit", strconv.Itoa(max(1, min(limit, 50))))
+
+	var data struct {
+		Tracks struct {
+			Items []*SpotifyTrack `json:"items"`
+		} `json:"tracks"`
+	}
+	if err := Spotify().get(ctx, "/search?"+query.Encode(), &data); err != nil {
+		return nil, err
+	}
+
+	return data.Tracks.Items, nil
+}
@@ -0,0 +1,286 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSpotify starts a fake Spotify API. api handles every non-token
+// request, the token endpoint always hands out "token-<n>".
+func newTestSpotify(t *testing.T, api http.HandlerFunc) (*SpotifyClient, *atomic.Int32) {
+	t.Helper()
+
+	tokens := &atomic.Int32{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		n := tokens.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token-` + string(rune('0'+n)) + `","expires_in":3600}`))
+	})
+	mux.HandleFunc("/v1/", api)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	c := NewSpotifyClient("id", "secret")
+	c.BaseURL = server.URL + "/v1"
+	c.AccountsURL = server.URL
+	c.RetryBaseDelay = time.Millisecond
+	// no keep-alive, so the transport itself never retries a dropped connection
+	c.HTTPClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	return c, tokens
+}
+
+type testTrack struct {
+	ID string `json:"id"`
+}
+
+func TestSpotifyGetRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// status codes (0 = drop the connection) of the consecutive responses, the last one is repeated
+		responses  []int
+		retryAfter string
+		wantCalls  int32
+		wantStatus int // 0 = success
+		wantErr    bool
+	}{
+		{"ok", []int{200}, "", 1, 0, false},
+		{"429 with Retry-After", []int{429, 200}, "0", 2, 0, false},
+		{"429 with a too long Retry-After", []int{429}, "120", 1, 429, true},
+		{"5xx backoff", []int{503, 500, 200}, "", 3, 0, false},
+		{"5xx exhausted", []int{502}, "", 3, 502, true},
+		{"404 is not retried", []int{404}, "", 1, 404, true},
+		{"network error", []int{0, 200}, "", 2, 0, false},
+		{"network error exhausted", []int{0}, "", 3, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &atomic.Int32{}
+			c, _ := newTestSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				status := tt.responses[min(n, len(tt.responses))-1]
+
+				switch {
+				case status == 0:
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+				case status == 200:
+					w.Write([]byte(`{"id":"abc"}`))
+				default:
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(status)
+				}
+			})
+			c.MaxRetries = 2
+
+			var track testTrack
+			err := c.get(context.Background(), "/tracks/abc", &track)
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if track.ID != "abc" {
+					t.Fatalf("track id = %q, want abc", track.ID)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var spotifyErr *SpotifyError
+			if tt.wantStatus != 0 && (!errors.As(err, &spotifyErr) || spotifyErr.StatusCode != tt.wantStatus) {
+				t.Fatalf("error = %v, want a spotify %d error", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSpotifyGetTimeoutIsRetried(t *testing.T) {
+	calls := &atomic.Int32{}
+	c, _ := newTestSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte(`{"id":"abc"}`))
+	})
+	c.HTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	var track testTrack
+	if err := c.get(context.Background(), "/tracks/abc", &track); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("calls = %d, want 2", got)
+	}
+}
+
+func TestSpotifyGetDecodeError(t *testing.T) {
+	calls := &atomic.Int32{}
+	c, _ := newTestSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`<html>not json</html>`))
+	})
+
+	var track testTrack
+	err := c.get(context.Background(), "/tracks/abc", &track)
+	if err == nil || !strings.Contains(err.Error(), "decode error") {
+		t.Fatalf("error = %v, want a decode error", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("calls = %d, want 1 (decode errors aren't retried)", got)
+	}
+}
+
+func TestSpotifyGetRefreshesRejectedToken(t *testing.T) {
+	c, tokens := newTestSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":"abc"}`))
+	})
+
+	var track testTrack
+	if err := c.get(context.Background(), "/tracks/abc", &track); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tokens.Load(); got != 2 {
+		t.Fatalf("token requests = %d, want 2", got)
+	}
+}
+
+func TestSpotifyGetCancelledContext(t *testing.T) {
+	c, _ := newTestSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c.RetryBaseDelay = 10 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var track testTrack
+	if err := c.get(ctx, "/tracks/abc", &track); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 2 * time.Second},
+		{"0", 0},
+		{"5", 5 * time.Second},
+		{" 7 ", 7 * time.Second},
+		{"-1", 2 * time.Second},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, 2*time.Second); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestSetSpotify(t *testing.T) {
+	previous := Spotify()
+	t.Cleanup(func() { SetSpotify(previous) })
+
+	c := NewSpotifyClient("id", "secret")
+	SetSpotify(c)
+	if Spotify() != c {
+		t.Fatal("Spotify() didn't return the client set through SetSpotify")
+	}
+}
+
+func TestSpotifyTokenRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// status codes (0 = drop the connection) of the consecutive token responses, the last one is repeated
+		responses  []int
+		retryAfter string
+		wantCalls  int32
+		wantStatus int // 0 = success
+		wantAuth   bool
+	}{
+		{"ok", []int{200}, "", 1, 0, false},
+		{"429 with Retry-After", []int{429, 200}, "0", 2, 0, false},
+		{"429 with a too long Retry-After", []int{429}, "120", 1, 429, false},
+		{"5xx backoff", []int{503, 500, 200}, "", 3, 0, false},
+		{"5xx exhausted", []int{502}, "", 3, 502, false},
+		{"rejected credentials aren't retried", []int{400}, "", 1, 400, true},
+		{"network error", []int{0, 200}, "", 2, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &atomic.Int32{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				status := tt.responses[min(n, len(tt.responses))-1]
+
+				switch {
+				case status == 0:
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+				case status == 200:
+					w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+				default:
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(status)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			c := NewSpotifyClient("id", "secret")
+			c.AccountsURL = server.URL
+			c.MaxRetries = 2
+			c.RetryBaseDelay = time.Millisecond
+			c.HTTPClient = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+			token, err := c.Token(context.Background())
+
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil || token != "token" {
+					t.Fatalf("Token() = %q, %v, want the token", token, err)
+				}
+				return
+			}
+
+			var spotifyErr *SpotifyError
+			if !errors.As(err, &spotifyErr) || spotifyErr.StatusCode != tt.wantStatus {
+				t.Fatalf("error = %v, want a spotify %d error", err, tt.wantStatus)
+			}
+			if errors.Is(err, ErrSpotifyAuth) != tt.wantAuth {
+				t.Fatalf("error = %v, want ErrSpotifyAuth = %v", err, tt.wantAuth)
+			}
+		})
+	}
+}
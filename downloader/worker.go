@@ -0,0 +1,334 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Fallback poll interval, in case a track was queued without a notify
+// (e.g. through the dashboard or a reclaimed lease)
+const workerPollInterval = 30 * time.Second
+
+// queueSignal wakes the worker pool dispatcher. Buffered with size 1 so
+// notifies never block and multiple notifies collapse into one wake up.
+var queueSignal = make(chan struct{}, 1)
+
+// NotifyQueue wakes the worker pool so newly queued tracks are picked up immediately
+func NotifyQueue() {
+	select {
+	case queueSignal <- struct{}{}:
+	default:
+	}
+}
+
+// WorkerPool downloads queued tracks with a fixed number of concurrent workers
+type WorkerPool struct {
+	app         core.App
+	concurrency int
+
+	// Decides when failed downloads are retried
+	Retry RetryPolicy
+
+	slots  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	done   chan struct{}
+
+	// Stop has nothing to wait for when the dispatcher never ran (e.g. the
+	// terminate hook of a non-serve command)
+	startOnce sync.Once
+	started   atomic.Bool
+
+	// cancel funcs of the in-flight downloads, keyed by track record id
+	runningMu sync.Mutex
+	running   map[string]context.CancelFunc
+}
+
+func NewWorkerPool(app core.App, concurrency int) *WorkerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &WorkerPool{
+		app:         app,
+		concurrency: concurrency,
+		Retry:       DefaultRetryPolicy,
+		slots:       make(chan struct{}, concurrency),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		running:     make(map[string]context.CancelFunc),
+	}
+}
+
+// Start runs the dispatcher in the background
+func (p *WorkerPool) Start() {
+	p.startOnce.Do(func() {
+		p.started.Store(true)
+		go p.dispatchLoop()
+		NotifyQueue()
+	})
+}
+
+// Stop stops claiming new tracks and waits (up to timeout) for the in-flight
+// downloads to finish. Returns false if the timeout was hit - those tracks keep
+// their lease and get reclaimed once it expires.
+func (p *WorkerPool) Stop(timeout time.Duration) bool {
+	p.cancel()
+	if !p.started.Load() {
+		return true
+	}
+	<-p.done
+
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// ======================================================================
+//  DISPATCHER
+// ======================================================================
+
+func (p *WorkerPool) dispatchLoop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(workerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.dispatch()
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-queueSignal:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch claims queued tracks for as long as there are free slots.
+// A slot is always acquired BEFORE a track is claimed, so rows are only
+// marked as downloading when a worker is actually free to take them.
+func (p *WorkerPool) dispatch() {
+	for p.ctx.Err() == nil {
+		select {
+		case p.slots <- struct{}{}: // acquire slot
+		default:
+			return // all workers busy
+		}
+
+		track, ctx, err := p.claimNext()
+		if err != nil || track == nil {
+			<-p.slots // release slot
+			if err != nil {
+				log.Println("Failed to claim queued track:", err)
+			}
+			return
+		}
+
+		p.wg.Add(1)
+		go func(ctx context.Context, track *core.Record) {
+			defer func() {
+				<-p.slots // release slot
+				p.wg.Done()
+				NotifyQueue()
+			}()
+
+			p.process(ctx, track)
+		}(ctx, track)
+	}
+}
+
+// claimNext claims the next queued track, or returns nil if the queue is empty.
+// The download context is registered as running BEFORE the claim, so a cancel
+// that comes in right after the claim always reaches the download.
+func (p *WorkerPool) claimNext() (*core.Record, context.Context, error) {
+	for {
+		tracks, err := p.app.FindRecordsByFilter(
+			"tracks",
+			"download_status='queued' && (next_attempt_at='' || next_attempt_at<=@now)",
+			"-priority,created", // highest priority first, FIFO within a priority
+			1,
+			0,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(tracks) == 0 {
+			return nil, nil, nil
+		}
+
+		ctx := p.register(tracks[0].Id)
+
+		track, err := ClaimTrack(p.app, tracks[0].Id, WorkerID)
+		if err != nil {
+			p.unregister(tracks[0].Id)
+		}
+		if errors.Is(err, ErrTrackNotClaimable) {
+			// someone else got it first, try the next one
+			continue
+		}
+
+		return track, ctx, err
+	}
+}
+
+// register creates the download context of the track and makes it cancellable by CancelTrack.
+// Not derived from the pool context - shutdown drains running downloads instead of killing them.
+func (p *WorkerPool) register(trackID string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p.runningMu.Lock()
+	p.running[trackID] = cancel
+	p.runningMu.Unlock()
+
+	return ctx
+}
+
+// unregister cancels the download context of the track and forgets it
+func (p *WorkerPool) unregister(trackID string) {
+	p.runningMu.Lock()
+	cancel, ok := p.running[trackID]
+	delete(p.running, trackID)
+	p.runningMu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// ======================================================================
+//  WORKER
+// ======================================================================
+
+func (p *WorkerPool) process(ctx context.Context, track *core.Record) {
+	defer p.unregister(track.Id)
+
+	// keep the lease alive while downloading, a lost lease stops the download
+	stopHeartbeat := KeepLeaseAlive(p.app, track.Id, WorkerID, func() { p.unregister(track.Id) })
+	_, err := DownloadTrack(ctx, p.app, track)
+	stopHeartbeat()
+
+	switch {
+	case errors.Is(err, ErrLeaseLost):
+		log.Printf("Download of track %s was taken over (%v), not saving it", track.Id, err)
+		return
+	case errors.Is(err, context.Canceled):
+		track.Set("download_status", "cancelled")
+		track.Set("next_attempt_at", "")
+		log.Printf("Download of track %s was cancelled", track.Id)
+	case err != nil:
+		p.handleFailure(track, err)
+	default:
+		track.Set("download_status", "completed")
+		track.Set("last_error", "")
+		track.Set("next_attempt_at", "")
+	}
+	ReleaseLease(track)
+	track.Set("download_phase", "")
+	if track.GetString("download_status") != "completed" {
+		track.Set("download_progress", 0)
+	}
+
+	// only if the track is still ours - it may have been cancelled (or reclaimed) meanwhile
+	if err := saveLeasedTrack(p.app, track, WorkerID); errors.Is(err, ErrLeaseLost) {
+		log.Printf("Track %s changed while downloading, dropping its %s status", track.Id, track.GetString("download_status"))
+	} else if err != nil {
+		log.Println("Failed to update queued track status:", err)
+	}
+}
+
+// handleFailure either schedules a retry or gives up on the track
+func (p *WorkerPool) handleFailure(track *core.Record, err error) {
+	attempts := track.GetInt("attempts")
+	track.Set("last_error", err.Error())
+
+	switch {
+	case IsPermanent(err):
+		track.Set("download_status", "failed")
+		track.Set("next_attempt_at", "")
+		log.Printf("Failed to download track %s (permanent): %v", track.Id, err)
+	case p.Retry.Exhausted(attempts):
+		track.Set("download_status", "dead")
+		track.Set("next_attempt_at", "")
+		log.Printf("Giving up on track %s after %d attempts: %v", track.Id, attempts, err)
+	default:
+		delay := p.Retry.Backoff(attempts)
+		track.Set("download_status", "queued")
+		track.Set("next_attempt_at", types.NowDateTime().Add(delay))
+		log.Printf("Failed to download track %s (attempt %d), retrying in %s: %v", track.Id, attempts, delay, err)
+	}
+}
+
+// ======================================================================
+//  CANCEL
+// ======================================================================
+
+var ErrTrackNotCancellable = errors.New("only queued or downloading tracks can be cancelled")
+
+// CancelTrack removes a queued track from the queue, or kills its download
+// if it is currently downloading (trackRef: see FindTrack). Returns the
+// cancelled record, or nil if the queued record was deleted.
+func (p *WorkerPool) CancelTrack(trackRef string) (*core.Record, error) {
+	var cancelled *core.Record
+
+	err := p.app.RunInTransaction(func(txApp core.App) error {
+		track, err := FindTrack(txApp, trackRef)
+		if err != nil {
+			return err
+		}
+
+		switch track.GetString("download_status") {
+		case "queued":
+			return txApp.Delete(track)
+		case "downloading":
+			p.runningMu.Lock()
+			cancel, ok := p.running[track.Id]
+			p.runningMu.Unlock()
+
+			if ok {
+				// the worker records the cancelled status once yt-dlp is killed
+				cancel()
+			} else {
+				// not running on this worker (another process, or left over from a crash).
+				// Its worker stops once the heartbeat notices the lease is gone and
+				// won't save over the cancelled status.
+				track.Set("download_status", "cancelled")
+				ReleaseLease(track)
+				if err := txApp.Save(track); err != nil {
+					return err
+				}
+			}
+
+			cancelled = track
+			return nil
+		default:
+			return ErrTrackNotCancellable
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelled, nil
+}
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(2, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2462348188",
+			"max": 0,
+			"min": 0,
+			"name": "provider",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(3, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text173254826",
+			"max": 0,
+			"min": 0,
+			"name": "provider_id",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		collection.AddIndex("idx_tracks_provider_id", false, "`provider`, `provider_id`", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// every existing track came from spotify
+		_, err = app.DB().NewQuery("UPDATE {{tracks}} SET [[provider]] = 'spotify', [[provider_id]] = [[spotify_track_id]]").Execute()
+		return err
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_tracks_provider_id")
+
+		// remove field
+		collection.Fields.RemoveById("text2462348188")
+
+		// remove field
+		collection.Fields.RemoveById("text173254826")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(14, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text4168063498",
+			"max": 0,
+			"min": 0,
+			"name": "isrc",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(15, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3542264624",
+			"max": 0,
+			"min": 0,
+			"name": "album_artist",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(16, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3906330985",
+			"max": 0,
+			"min": 0,
+			"name": "album_type",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(17, []byte(`{
+			"hidden": false,
+			"id": "number3967355192",
+			"max": null,
+			"min": null,
+			"name": "total_tracks",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(18, []byte(`{
+			"hidden": false,
+			"id": "bool2594623564",
+			"name": "explicit",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "bool"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(19, []byte(`{
+			"hidden": false,
+			"id": "number3150426505",
+			"max": null,
+			"min": null,
+			"name": "popularity",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// used to dedupe tracks across providers
+		collection.AddIndex("idx_tracks_isrc", false, "`isrc`", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_tracks_isrc")
+
+		// remove field
+		collection.Fields.RemoveById("text4168063498")
+
+		// remove field
+		collection.Fields.RemoveById("text3542264624")
+
+		// remove field
+		collection.Fields.RemoveById("text3906330985")
+
+		// remove field
+		collection.Fields.RemoveById("number3967355192")
+
+		// remove field
+		collection.Fields.RemoveById("bool2594623564")
+
+		// remove field
+		collection.Fields.RemoveById("number3150426505")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "pbc_1758691358",
+			"hidden": false,
+			"id": "relation1758691358",
+			"maxSelect": 999,
+			"minSelect": 0,
+			"name": "artists",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"cascadeDelete": false,
+			"collectionId": "pbc_4108470095",
+			"hidden": false,
+			"id": "relation1229955622",
+			"maxSelect": 1,
+			"minSelect": 0,
+			"name": "album_record",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		return backfillArtistsAndAlbums(app)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("relation1758691358")
+
+		// remove field
+		collection.Fields.RemoveById("relation1229955622")
+
+		return app.Save(collection)
+	})
+}
+
+// backfillArtistsAndAlbums creates the artist/album records of the existing
+// tracks from their comma-joined "artist"/"artist_id" strings and links them
+func backfillArtistsAndAlbums(app core.App) error {
+	artistsCol, err := app.FindCollectionByNameOrId("pbc_1758691358")
+	if err != nil {
+		return err
+	}
+	albumsCol, err := app.FindCollectionByNameOrId("pbc_4108470095")
+	if err != nil {
+		return err
+	}
+
+	tracks, err := app.FindAllRecords("pbc_327047008")
+	if err != nil {
+		return err
+	}
+
+	// "<provider>:<provider_id>" -> record id
+	artistRecords := map[string]string{}
+	albumRecords := map[string]string{}
+
+	for _, track := range tracks {
+		provider := track.GetString("provider")
+
+		ids := splitJoined(track.GetString("artist_id"))
+		names := splitJoined(track.GetString("artist"))
+		if len(names) != len(ids) {
+			// a name contained ", " - it can't be split reliably, so keep the
+			// whole string (a metadata refresh puts the real names in place)
+			names = make([]string, len(ids))
+			for i := range names {
+				names[i] = track.GetString("artist")
+			}
+		}
+
+		artists := []string{}
+		for i, id := range ids {
+			key := provider + ":" + id
+			if _, ok := artistRecords[key]; !ok {
+				artist := core.NewRecord(artistsCol)
+				artist.Set("provider", provider)
+				artist.Set("provider_id", id)
+				artist.Set("name", names[i])
+				if err := app.Save(artist); err != nil {
+					return err
+				}
+				artistRecords[key] = artist.Id
+			}
+			artists = append(artists, artistRecords[key])
+		}
+		track.Set("artists", artists)
+
+		if albumID := track.GetString("album_id"); albumID != "" {
+			key := provider + ":" + albumID
+			if _, ok := albumRecords[key]; !ok {
+				album := core.NewRecord(albumsCol)
+				album.Set("provider", provider)
+				album.Set("provider_id", albumID)
+				album.Set("name", track.GetString("album"))
+				album.Set("release_date", track.GetString("release_date"))
+				album.Set("album_type", track.GetString("album_type"))
+				album.Set("total_tracks", track.GetInt("total_tracks"))
+				album.Set("cover_url", track.GetString("cover_url"))
+				if err := app.Save(album); err != nil {
+					return err
+				}
+				albumRecords[key] = album.Id
+			}
+			track.Set("album_record", albumRecords[key])
+		}
+
+		if err := app.Save(track); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func splitJoined(s string) []string {
+	parts := []string{}
+	for _, p := range strings.Split(s, ", ") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,146 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(23, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2776776943",
+			"max": 0,
+			"min": 0,
+			"name": "source_url",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(24, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2503744609",
+			"max": 0,
+			"min": 0,
+			"name": "source_id",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(25, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2990449492",
+			"max": 0,
+			"min": 0,
+			"name": "source_title",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(26, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3945149999",
+			"max": 0,
+			"min": 0,
+			"name": "source_channel",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(27, []byte(`{
+			"hidden": false,
+			"id": "number3313481234",
+			"max": null,
+			"min": null,
+			"name": "source_duration",
+			"onlyInt": false,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(28, []byte(`{
+			"hidden": false,
+			"id": "number2364754160",
+			"max": null,
+			"min": null,
+			"name": "match_score",
+			"onlyInt": false,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text2776776943")
+
+		// remove field
+		collection.Fields.RemoveById("text2503744609")
+
+		// remove field
+		collection.Fields.RemoveById("text2990449492")
+
+		// remove field
+		collection.Fields.RemoveById("text3945149999")
+
+		// remove field
+		collection.Fields.RemoveById("number3313481234")
+
+		// remove field
+		collection.Fields.RemoveById("number2364754160")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"sort"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// concurrent queue requests could create the same track twice - keep
+		// the completed (or else the oldest) copy so the index can be created
+		duplicateIds := []string{}
+		err = app.DB().NewQuery(
+			"SELECT [[spotify_track_id]] FROM {{tracks}} WHERE [[spotify_track_id]] != '' GROUP BY [[spotify_track_id]] HAVING COUNT(*) > 1",
+		).Column(&duplicateIds)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range duplicateIds {
+			tracks, err := app.FindAllRecords(collection, dbx.HashExp{"spotify_track_id": id})
+			if err != nil {
+				return err
+			}
+			sort.SliceStable(tracks, func(i, j int) bool {
+				return tracks[i].GetDateTime("created").Before(tracks[j].GetDateTime("created"))
+			})
+
+			keep := tracks[0]
+			for _, track := range tracks {
+				if track.GetString("download_status") == "completed" {
+					keep = track
+					break
+				}
+			}
+
+			for _, track := range tracks {
+				if track.Id == keep.Id {
+					continue
+				}
+				if err := app.Delete(track); err != nil {
+					return err
+				}
+			}
+		}
+
+		// tracks from other providers don't have a spotify id
+		collection.AddIndex("idx_tracks_spotify_track_id", true, "`spotify_track_id`", "`spotify_track_id` != ''")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_tracks_spotify_track_id")
+
+		return app.Save(collection)
+	})
+}
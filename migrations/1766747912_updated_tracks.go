@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"sort"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		// concurrent queue requests (e.g. by MusicBrainz id or ISRC) could create
+		// the same track twice - keep the completed (or else the oldest) copy so
+		// the unique index can be created
+		duplicates := []struct {
+			Provider   string `db:"provider"`
+			ProviderID string `db:"provider_id"`
+		}{}
+		err = app.DB().NewQuery(
+			"SELECT [[provider]], [[provider_id]] FROM {{tracks}} WHERE [[provider_id]] != '' GROUP BY [[provider]], [[provider_id]] HAVING COUNT(*) > 1",
+		).All(&duplicates)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range duplicates {
+			tracks, err := app.FindAllRecords(collection, dbx.HashExp{"provider": d.Provider, "provider_id": d.ProviderID})
+			if err != nil {
+				return err
+			}
+			sort.SliceStable(tracks, func(i, j int) bool {
+				return tracks[i].GetDateTime("created").Before(tracks[j].GetDateTime("created"))
+			})
+
+			keep := tracks[0]
+			for _, track := range tracks {
+				if track.GetString("download_status") == "completed" {
+					keep = track
+					break
+				}
+			}
+
+			for _, track := range tracks {
+				if track.Id == keep.Id {
+					continue
+				}
+				if err := app.Delete(track); err != nil {
+					return err
+				}
+			}
+		}
+
+		collection.RemoveIndex("idx_tracks_provider_id")
+		collection.AddIndex("idx_tracks_provider_id", true, "`provider`, `provider_id`", "`provider_id` != ''")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_327047008")
+		if err != nil {
+			return err
+		}
+
+		collection.RemoveIndex("idx_tracks_provider_id")
+		collection.AddIndex("idx_tracks_provider_id", false, "`provider`, `provider_id`", "")
+
+		return app.Save(collection)
+	})
+}
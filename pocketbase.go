@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -8,8 +11,10 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"api.groovio/downloader"
+	"api.groovio/webhooks"
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
@@ -20,7 +25,14 @@ import (
 	_ "api.groovio/migrations"
 )
 
-var downloadSemaphore = make(chan struct{}, 2)
+// Max number of tracks downloaded at the same time (DOWNLOAD_CONCURRENCY env var)
+const defaultDownloadConcurrency = 2
+
+// How long to wait for in-flight downloads on shutdown
+const workerDrainTimeout = 30 * time.Second
+
+// How often an (otherwise idle) SSE connection gets a keep-alive comment
+const sseHeartbeatInterval = 15 * time.Second
 
 func main() {
 	app := pocketbase.New()
@@ -34,182 +46,327 @@ func main() {
 		Automigrate: isGoRun,
 	})
 
+	// Adds the "metadata refresh" command (re-fetches the metadata of the stored tracks)
+	downloader.RegisterMetadataCommand(app, app.RootCmd)
+
+	concurrency := defaultDownloadConcurrency
+	if v, err := strconv.Atoi(os.Getenv("DOWNLOAD_CONCURRENCY")); err == nil && v > 0 {
+		concurrency = v
+	}
+	workerPool := downloader.NewWorkerPool(app, concurrency)
+	eventBroker := downloader.NewEventBroker()
+	webhookDispatcher := webhooks.NewDispatcher(app)
+	if v, err := strconv.Atoi(os.Getenv("DOWNLOAD_MAX_ATTEMPTS")); err == nil && v > 0 {
+		workerPool.Retry.MaxAttempts = v
+	}
+
+	// Let in-flight downloads finish before shutting down
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		if !workerPool.Stop(workerDrainTimeout) {
+			log.Println("Worker pool did not drain in time, unfinished tracks will be reclaimed after their lease expires")
+		}
+		return e.Next()
+	})
+
+	// Feed track status/progress changes to the SSE subscribers and webhooks
+	app.OnRecordAfterCreateSuccess("tracks").BindFunc(func(e *core.RecordEvent) error {
+		eventBroker.PublishTrack(e.Record)
+		webhookDispatcher.HandleTrackChange(e.Record)
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("tracks").BindFunc(func(e *core.RecordEvent) error {
+		eventBroker.PublishTrack(e.Record)
+		webhookDispatcher.HandleTrackChange(e.Record)
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("tracks").BindFunc(func(e *core.RecordEvent) error {
+		eventBroker.ForgetTrack(e.Record)
+		webhookDispatcher.ForgetTrack(e.Record)
+		return e.Next()
+	})
+
+	// Pick up match config changes (see downloader.LoadMatchConfig) without a restart
+	reloadMatchConfig := func(e *core.RecordEvent) error {
+		downloader.ReloadMatchConfig(e.App)
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("settings").BindFunc(reloadMatchConfig)
+	app.OnRecordAfterUpdateSuccess("settings").BindFunc(reloadMatchConfig)
+	app.OnRecordAfterDeleteSuccess("settings").BindFunc(reloadMatchConfig)
+
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 
 		// 1. Queue the track for download
 		se.Router.POST("/api/queue-track", func(e *core.RequestEvent) error {
 			var payload downloader.DownloadRequest
 			if err := e.BindBody(&payload); err != nil {
-				return e.JSON(http.StatusBadRequest, map[string]string{
-					"error": "Invalid request body: " + err.Error(),
-				})
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
 			}
 
-			// Start the job in a background goroutine
-			go func() {
-				// The PocketBase app pointer is safe to use in a goroutine
-				record, err := downloader.QueueTrack(app, payload)
-				if err != nil {
-					// Log the error for internal tracking
-					log.Printf("Adding track to the queue FAILED for track ID %s: %v", payload.SpotifyTrackID, err)
-					return // Job failed, nothing more to do for this background task.
-				}
-				
-				// Optional: You could implement a webhook or a live-update mechanism (like websockets)
-				// to notify the client when the record (and file) is ready.
+			record, status, err := downloader.QueueTrack(e.Request.Context(), app, payload)
+			if err != nil {
+				log.Printf("Adding track to the queue FAILED for track %s: %v", payload.Identity(), err)
+				return queueError(e, err)
+			}
+
+			if status == downloader.QueueCreated {
 				log.Printf("Track was succesfully added to the queue. Track ID: %s", record.Id)
-			}()
+				return e.JSON(http.StatusCreated, record)
+			}
 
-			// Return an immediate success response indicating the job started.
-			return e.JSON(http.StatusOK, map[string]string{
-				"status": "Track was added to the queue",
-			})
+			// Duplicate request - return the existing (possibly re-queued) record
+			return e.JSON(http.StatusOK, record)
 		})
 
-		// 2. Register cron job for downloading queued tracks
-		app.Cron().MustAdd("queue_worker", "*/1 * * * *", func() {
-			// Find queued tracks
-			tracks, err := app.FindRecordsByFilter(
-				"tracks",
-				"download_status='queued'",
-				"-created",
-				cap(downloadSemaphore),
-				0,
-			)
+		// Queue anything by its Spotify URL/URI (track, album, playlist or artist)
+		se.Router.POST("/api/queue", func(e *core.RequestEvent) error {
+			var payload downloader.QueueRequest
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			response, err := downloader.Queue(e.Request.Context(), app, payload)
+			if errors.Is(err, downloader.ErrSpotifyNotFound) {
+				return apiError(e, http.StatusNotFound, "spotify_not_found", "Not found on Spotify")
+			}
 			if err != nil {
-				// No rows returned
-				return
+				log.Printf("Adding %s to the queue FAILED: %v", payload.URL, err)
+				return queueError(e, err)
 			}
 
-			for _, track := range tracks {
-				// mark job as downloading
-				track.Set("download_status", "downloading")
-				app.Save(track)
-
-				// fire worker
-				go func(track *core.Record) {
-					downloadSemaphore <- struct{}{} // acquire slot
-					defer func() { <-downloadSemaphore }() // release slot
-
-					_, err = downloader.DownloadTrack(app, track)
-					if err != nil {
-						track.Set("download_status", "failed")
-						fmt.Printf("Failed to download track %s", err.Error())
-					} else {
-						track.Set("download_status", "completed")
-					}
+			if response.Created {
+				return e.JSON(http.StatusCreated, response)
+			}
+			return e.JSON(http.StatusOK, response)
+		})
 
-					if err := app.Save(track); err != nil {
-						log.Println("Failed to update queued track status:", err)
-					}
+		// 1b. Queue many tracks at once
+		se.Router.POST("/api/queue-tracks", func(e *core.RequestEvent) error {
+			var payload downloader.BatchQueueRequest
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			results, err := downloader.QueueTracks(e.Request.Context(), app, payload)
+			if err != nil {
+				log.Printf("Adding %d tracks to the queue FAILED: %v", len(payload.SpotifyTrackIDs), err)
+				return queueError(e, err)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{"results": results})
+		})
+
+		// 1c. Queue every track of an album
+		se.Router.POST("/api/queue-album", func(e *core.RequestEvent) error {
+			var payload downloader.AlbumQueueRequest
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			result, err := downloader.QueueAlbum(e.Request.Context(), app, payload)
+			if errors.Is(err, downloader.ErrSpotifyNotFound) {
+				return apiError(e, http.StatusNotFound, "spotify_album_not_found", "Spotify album not found")
+			}
+			if err != nil {
+				log.Printf("Adding album %s to the queue FAILED: %v", payload.SpotifyAlbumID, err)
+				return queueError(e, err)
+			}
+
+			return e.JSON(http.StatusOK, result)
+		})
+
+		// Aggregated download status of an album
+		se.Router.GET("/api/albums/{spotifyAlbumId}/status", func(e *core.RequestEvent) error {
+			status, err := downloader.GetAlbumStatus(app, e.Request.PathValue("spotifyAlbumId"))
+			if errors.Is(err, downloader.ErrAlbumNotFound) {
+				return apiError(e, http.StatusNotFound, "album_not_found", "No tracks of this album were queued")
+			}
+			if err != nil {
+				return apiError(e, http.StatusInternalServerError, "internal_error", "Failed to load album status")
+			}
+
+			return e.JSON(http.StatusOK, status)
+		})
+
+		// 1d. Queue an artist's discography
+		se.Router.POST("/api/queue-artist", func(e *core.RequestEvent) error {
+			var payload downloader.ArtistQueueRequest
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			result, err := downloader.QueueArtist(e.Request.Context(), app, payload)
+			if errors.Is(err, downloader.ErrSpotifyNotFound) {
+				return apiError(e, http.StatusNotFound, "spotify_artist_not_found", "Spotify artist not found")
+			}
+			if err != nil {
+				log.Printf("Adding artist %s to the queue FAILED: %v", payload.SpotifyArtistID, err)
+				return queueError(e, err)
+			}
+
+			return e.JSON(http.StatusOK, result)
+		})
+
+		// 1e. Register a Spotify playlist - its tracks are queued and kept in sync
+		se.Router.POST("/api/playlists", func(e *core.RequestEvent) error {
+			var payload downloader.PlaylistRequest
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			playlist, created, err := downloader.QueuePlaylist(e.Request.Context(), app, payload)
+			if errors.Is(err, downloader.ErrSpotifyNotFound) {
+				return apiError(e, http.StatusNotFound, "spotify_playlist_not_found", "Spotify playlist not found")
+			}
+			if err != nil {
+				log.Printf("Registering playlist %s FAILED: %v", payload.SpotifyPlaylistID, err)
+				return queueError(e, err)
+			}
+
+			if created {
+				return e.JSON(http.StatusCreated, playlist)
+			}
+			return e.JSON(http.StatusOK, playlist)
+		})
+
+		// Periodically pick up tracks added to (or removed from) the registered playlists
+		app.Cron().MustAdd("playlist_sync", "*/15 * * * *", func() {
+			downloader.SyncAllPlaylists(app)
+		})
+
+		// Look up the images of the Spotify artists created since the last run
+		app.Cron().MustAdd("artist_images", "*/5 * * * *", func() {
+			if n, err := downloader.FillSpotifyArtistImages(context.Background(), app); err != nil {
+				log.Println("Failed to fill artist images:", err)
+			} else if n > 0 {
+				log.Printf("Filled the images of %d artists", n)
+			}
+		})
+
+		// 2. Start the worker pool for downloading queued tracks
+
+		// Reclaim tracks stuck in "downloading" (e.g. after a crash/restart)
+		if n, err := downloader.ReclaimExpiredLeases(app, workerPool.Retry); err != nil {
+			log.Println("Failed to reclaim expired leases:", err)
+		} else if n > 0 {
+			log.Printf("Reclaimed %d tracks with expired leases", n)
+		}
 
-					app.Save(track)
-				}(track)
+		app.Cron().MustAdd("lease_reaper", "*/1 * * * *", func() {
+			if n, err := downloader.ReclaimExpiredLeases(app, workerPool.Retry); err != nil {
+				log.Println("Failed to reclaim expired leases:", err)
+			} else if n > 0 {
+				log.Printf("Reclaimed %d tracks with expired leases", n)
 			}
 		})
 
+		// the settings hooks above reload it on changes
+		downloader.ReloadMatchConfig(app)
+		workerPool.Start()
+
 		// 3. Expose endpoint for playing/download tracks
-		se.Router.GET("/api/play-track/{spotifyTrackId}", func(e *core.RequestEvent) error {
-			spotifyTrackId := e.Request.PathValue("spotifyTrackId")
-			if spotifyTrackId == "" {
-				return e.JSON(http.StatusBadRequest, map[string]string{"error": "Missing spotifyTrackId"})
+		// (trackId is a spotify track id, the track record id or "<provider>:<provider_id>")
+		se.Router.GET("/api/play-track/{trackId}", func(e *core.RequestEvent) error {
+			trackId := e.Request.PathValue("trackId")
+			if trackId == "" {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Missing trackId")
 			}
 
-			record, err := app.FindFirstRecordByData("tracks", "spotify_track_id", spotifyTrackId)
+			record, err := downloader.FindTrack(app, trackId)
 			if err != nil {
-				return e.JSON(http.StatusNotFound, "Track not found")
+				return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
 			}
 
 			fileName := record.GetString("file")
 			if fileName == "" {
-				return e.JSON(http.StatusNotFound, "Track file not available")
+				return apiError(e, http.StatusNotFound, "file_not_available", "Track file not available")
 			}
 
 			key := record.BaseFilesPath() + "/" + fileName
 
 			fsys, err := app.NewFilesystem()
 			if err != nil {
-				return e.JSON(http.StatusInternalServerError, "Failed to initialize filesystem")
+				return apiError(e, http.StatusInternalServerError, "internal_error", "Failed to initialize filesystem")
 			}
 			defer fsys.Close()
 
 			// get file size
 			stat, err := fsys.Attributes(key)
 			if err != nil {
-				return e.JSON(http.StatusNotFound, "File not found")
+				return apiError(e, http.StatusNotFound, "file_not_found", "File not found")
 			}
 			fileSize := stat.Size
 
 			// Get reader (seekable)
 			reader, err := fsys.GetReader(key)
 			if err != nil {
-				return e.JSON(http.StatusNotFound, "File not found")
+				return apiError(e, http.StatusNotFound, "file_not_found", "File not found")
 			}
 			defer reader.Close()
 
 			rangeHeader := e.Request.Header.Get("Range")
 
 			if rangeHeader != "" {
-					if !strings.HasPrefix(rangeHeader, "bytes=") {
-							return e.JSON(http.StatusBadRequest, "Invalid Range header")
-					}
+				if !strings.HasPrefix(rangeHeader, "bytes=") {
+					return apiError(e, http.StatusBadRequest, "invalid_range", "Invalid Range header")
+				}
 
-					// Strip "bytes="
-					byteRange := strings.TrimPrefix(rangeHeader, "bytes=")
+				// Strip "bytes="
+				byteRange := strings.TrimPrefix(rangeHeader, "bytes=")
 
-					// Support only single ranges (Chrome sometimes tries multiple)
-					if strings.Contains(byteRange, ",") {
-							// respond with full file (most players accept this)
-							byteRange = strings.Split(byteRange, ",")[0]
-					}
+				// Support only single ranges (Chrome sometimes tries multiple)
+				if strings.Contains(byteRange, ",") {
+					// respond with full file (most players accept this)
+					byteRange = strings.Split(byteRange, ",")[0]
+				}
 
-					parts := strings.Split(byteRange, "-")
-					if len(parts) != 2 {
-							return e.JSON(http.StatusBadRequest, "Invalid Range format")
-					}
+				parts := strings.Split(byteRange, "-")
+				if len(parts) != 2 {
+					return apiError(e, http.StatusBadRequest, "invalid_range", "Invalid Range format")
+				}
 
-					// Parse start
-					start, err := strconv.ParseInt(parts[0], 10, 64)
-					if err != nil {
-							start = 0
-					}
+				// Parse start
+				start, err := strconv.ParseInt(parts[0], 10, 64)
+				if err != nil {
+					start = 0
+				}
 
-					// Parse end (optional)
-					var end int64
-					if parts[1] == "" { // bytes=start-
-							end = fileSize - 1
-					} else {
-							end, err = strconv.ParseInt(parts[1], 10, 64)
-							if err != nil || end >= fileSize {
-									end = fileSize - 1
-							}
+				// Parse end (optional)
+				var end int64
+				if parts[1] == "" { // bytes=start-
+					end = fileSize - 1
+				} else {
+					end, err = strconv.ParseInt(parts[1], 10, 64)
+					if err != nil || end >= fileSize {
+						end = fileSize - 1
 					}
+				}
 
-					if start < 0 || start >= fileSize {
-							return e.JSON(http.StatusRequestedRangeNotSatisfiable, "Invalid Range start")
-					}
+				if start < 0 || start >= fileSize {
+					return apiError(e, http.StatusRequestedRangeNotSatisfiable, "invalid_range", "Invalid Range start")
+				}
 
-					chunkSize := end - start + 1
-					if chunkSize < 1 {
-							return e.JSON(http.StatusRequestedRangeNotSatisfiable, "Invalid Range")
-					}
+				chunkSize := end - start + 1
+				if chunkSize < 1 {
+					return apiError(e, http.StatusRequestedRangeNotSatisfiable, "invalid_range", "Invalid Range")
+				}
 
-					// Seek
-					_, err = reader.Seek(start, io.SeekStart)
-					if err != nil {
-							return e.JSON(500, "Seek failed")
-					}
+				// Seek
+				_, err = reader.Seek(start, io.SeekStart)
+				if err != nil {
+					return apiError(e, http.StatusInternalServerError, "internal_error", "Seek failed")
+				}
 
-					// Headers
-					e.Response.Header().Set("Content-Type", "audio/mpeg")
-					e.Response.Header().Set("Accept-Ranges", "bytes")
-					e.Response.Header().Set("Content-Length", fmt.Sprintf("%d", chunkSize))
-					e.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
+				// Headers
+				e.Response.Header().Set("Content-Type", "audio/mpeg")
+				e.Response.Header().Set("Accept-Ranges", "bytes")
+				e.Response.Header().Set("Content-Length", fmt.Sprintf("%d", chunkSize))
+				e.Response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
 
-					e.Response.WriteHeader(206)
+				e.Response.WriteHeader(206)
 
-					_, err = io.CopyN(e.Response, reader, chunkSize)
-					return err
+				_, err = io.CopyN(e.Response, reader, chunkSize)
+				return err
 			}
 			// No Range header → full file
 			e.Response.Header().Set("Content-Type", "audio/mpeg")
@@ -220,47 +377,252 @@ func main() {
 			return err
 		})
 
-
 		// 4. Expose endpoint for checking if tracks audio exist
 		se.Router.GET("/api/check-tracks", func(e *core.RequestEvent) error {
 			trackIdsQuery := e.Request.URL.Query().Get("ids")
 			trackIds := strings.Split(trackIdsQuery, ",")
 
-			// Build filter
-			filters := make([]string, len(trackIds))
+			// Build filter (ids are spotify track ids, track record ids or "<provider>:<provider_id>")
+			filters := []string{}
 			params := make(dbx.Params)
+			requested := make(map[string]struct{})
 			for i, id := range trackIds {
-					key := fmt.Sprintf("id%d", i)
-					filters[i] = "spotify_track_id = {:" + key + "}"
-					params[key] = strings.TrimSpace(id)
+				id = strings.TrimSpace(id)
+				if id == "" {
+					continue
+				}
+				requested[id] = struct{}{}
+
+				key := fmt.Sprintf("id%d", i)
+				if provider, providerId, ok := strings.Cut(id, ":"); ok {
+					filters = append(filters, "(provider = {:p"+key+"} && provider_id = {:"+key+"})")
+					params["p"+key] = provider
+					params[key] = providerId
+				} else {
+					filters = append(filters, "(spotify_track_id = {:"+key+"} || id = {:"+key+"})")
+					params[key] = id
+				}
+			}
+			if len(filters) == 0 {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Missing ids")
 			}
 
 			// Seperate filters with OR operator
 			filter := strings.Join(filters, " || ")
 
 			// Fetch tracks
-			tracks, _ := app.FindRecordsByFilter(
-					"tracks",    // collection
-					filter,      // filter string
-					"",          // sort
-					0,           // limit 0 = no limit
-					0,           // offset
-					params,
+			tracks, err := app.FindRecordsByFilter(
+				"tracks", // collection
+				filter,   // filter string
+				"",       // sort
+				0,        // limit 0 = no limit
+				0,        // offset
+				params,
 			)
+			if err != nil {
+				return apiError(e, http.StatusInternalServerError, "internal_error", "Failed to load tracks")
+			}
 
-			// Map tracks to spotify_track_id <-> download_status
+			// Map the requested ids <-> download_status
 			mappedTracks := make(map[string]string)
 			for _, track := range tracks {
-					status := track.GetString("download_status")
-					if status != "" {
-							spotifyTrackId := track.GetString("spotify_track_id")
-							mappedTracks[spotifyTrackId] = status
+				status := track.GetString("download_status")
+				if status == "" {
+					continue
+				}
+				for _, ref := range downloader.TrackRefs(track) {
+					if _, ok := requested[ref]; ok {
+						mappedTracks[ref] = status
 					}
+				}
 			}
 
 			return e.JSON(http.StatusOK, mappedTracks)
 		})
 
+		// 5. Admin endpoint for bumping/demoting a queued track
+		se.Router.POST("/api/queue-track/{trackId}/priority", func(e *core.RequestEvent) error {
+			trackId := e.Request.PathValue("trackId")
+
+			var payload struct {
+				Priority string `json:"priority"`
+			}
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			priority, err := downloader.ParsePriority(payload.Priority)
+			if err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_priority", err.Error())
+			}
+
+			record, err := downloader.SetTrackPriority(app, trackId, priority)
+			if errors.Is(err, downloader.ErrTrackNotQueued) {
+				return apiError(e, http.StatusConflict, "track_not_queued", err.Error())
+			}
+			if err != nil {
+				return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
+			}
+
+			return e.JSON(http.StatusOK, record)
+		}).Bind(apis.RequireSuperuserAuth())
+
+		// 6. Cancel a queued or in-flight download
+		se.Router.DELETE("/api/queue-track/{trackId}", func(e *core.RequestEvent) error {
+			trackId := e.Request.PathValue("trackId")
+
+			record, err := workerPool.CancelTrack(trackId)
+			if errors.Is(err, downloader.ErrTrackNotCancellable) {
+				return apiError(e, http.StatusConflict, "track_not_cancellable", err.Error())
+			}
+			if err != nil {
+				return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
+			}
+
+			if record == nil {
+				return e.JSON(http.StatusOK, map[string]string{
+					"status": "Track was removed from the queue",
+				})
+			}
+
+			return e.JSON(http.StatusAccepted, map[string]string{
+				"status": "Track download is being cancelled",
+			})
+		})
+
+		// 7. Stream status/progress changes of the requested tracks (Server-Sent Events)
+		se.Router.GET("/api/queue/events", func(e *core.RequestEvent) error {
+			ids := []string{}
+			for _, id := range strings.Split(e.Request.URL.Query().Get("ids"), ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					ids = append(ids, id)
+				}
+			}
+			if len(ids) == 0 {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Missing ids")
+			}
+
+			// Resume support - browsers send the header on reconnect, the query param is for manual resumes
+			lastEventId := e.Request.Header.Get("Last-Event-ID")
+			if lastEventId == "" {
+				lastEventId = e.Request.URL.Query().Get("lastEventId")
+			}
+
+			// disable the global write deadline for the long-lived connection
+			rc := http.NewResponseController(e.Response)
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return apiError(e, http.StatusInternalServerError, "internal_error", "Failed to initialize SSE connection")
+			}
+
+			events, backlog, resumed, unsubscribe := eventBroker.Subscribe(ids, lastEventId)
+			defer unsubscribe()
+
+			// Couldn't resume from the last event - start with the current state instead
+			if !resumed {
+				backlog = downloader.Snapshot(app, ids, eventBroker.LastEventID())
+			}
+
+			e.Response.Header().Set("Content-Type", "text/event-stream")
+			e.Response.Header().Set("Cache-Control", "no-store")
+			e.Response.Header().Set("X-Accel-Buffering", "no")
+			e.Response.WriteHeader(http.StatusOK)
+
+			writeEvent := func(event downloader.TrackEvent) error {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(e.Response, "id: %s\nevent: track\ndata: %s\n\n", event.ID, data); err != nil {
+					return err
+				}
+				return e.Flush()
+			}
+
+			for _, event := range backlog {
+				if err := writeEvent(event); err != nil {
+					return nil
+				}
+			}
+			if err := e.Flush(); err != nil {
+				return nil
+			}
+
+			heartbeat := time.NewTicker(sseHeartbeatInterval)
+			defer heartbeat.Stop()
+
+			for {
+				select {
+				case <-e.Request.Context().Done():
+					return nil
+				case <-heartbeat.C:
+					if _, err := fmt.Fprint(e.Response, ": heartbeat\n\n"); err != nil {
+						return nil
+					}
+					if err := e.Flush(); err != nil {
+						return nil
+					}
+				case event := <-events:
+					if err := writeEvent(event); err != nil {
+						return nil
+					}
+				}
+			}
+		})
+
+		// 8. Retry pending webhook deliveries
+		app.Cron().MustAdd("webhook_deliveries", "*/1 * * * *", func() {
+			webhookDispatcher.DeliverPending()
+		})
+
+		// 9. Admin endpoint for sending a test ("ping") delivery to a webhook
+		se.Router.POST("/api/webhooks/{id}/test", func(e *core.RequestEvent) error {
+			delivery, err := webhookDispatcher.SendTest(e.Request.PathValue("id"))
+			if errors.Is(err, webhooks.ErrWebhookNotFound) {
+				return apiError(e, http.StatusNotFound, "webhook_not_found", err.Error())
+			}
+			if err != nil {
+				return apiError(e, http.StatusInternalServerError, "internal_error", err.Error())
+			}
+
+			return e.JSON(http.StatusOK, delivery)
+		}).Bind(apis.RequireSuperuserAuth())
+
+		// 10. Admin endpoint for pinning the track/video a track is downloaded from (re-queues the
+		// track), an empty url unpins it
+		se.Router.POST("/api/tracks/{trackId}/source", func(e *core.RequestEvent) error {
+			var payload struct {
+				URL string `json:"url"`
+			}
+			if err := e.BindBody(&payload); err != nil {
+				return apiError(e, http.StatusBadRequest, "invalid_request", "Invalid request body: "+err.Error())
+			}
+
+			record, err := downloader.SetSourceOverride(app, e.Request.PathValue("trackId"), payload.URL)
+			if errors.Is(err, downloader.ErrInvalidRequest) {
+				return apiError(e, http.StatusBadRequest, "invalid_source_url", err.Error())
+			}
+			if errors.Is(err, downloader.ErrTrackDownloading) {
+				return apiError(e, http.StatusConflict, "track_downloading", err.Error())
+			}
+			if err != nil {
+				return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
+			}
+
+			return e.JSON(http.StatusOK, record)
+		}).Bind(apis.RequireSuperuserAuth())
+
+		se.Router.DELETE("/api/tracks/{trackId}/source", func(e *core.RequestEvent) error {
+			record, err := downloader.ClearSourceOverride(app, e.Request.PathValue("trackId"))
+			if errors.Is(err, downloader.ErrTrackDownloading) {
+				return apiError(e, http.StatusConflict, "track_downloading", err.Error())
+			}
+			if err != nil {
+				return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
+			}
+
+			return e.JSON(http.StatusOK, record)
+		}).Bind(apis.RequireSuperuserAuth())
+
 		// Serve static files from pb_public
 		se.Router.GET("/{path...}", apis.Static(os.DirFS("./pb_public"), false))
 
@@ -271,3 +633,30 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// apiError writes the JSON error body used by all custom endpoints:
+// {"code": "machine_readable_code", "error": "Human readable message"}
+func apiError(e *core.RequestEvent, status int, code string, message string) error {
+	return e.JSON(status, map[string]string{
+		"code":  code,
+		"error": message,
+	})
+}
+
+// queueError maps the downloader queue errors to HTTP responses
+func queueError(e *core.RequestEvent, err error) error {
+	switch {
+	case errors.Is(err, downloader.ErrInvalidRequest):
+		return apiError(e, http.StatusBadRequest, "invalid_request", err.Error())
+	case errors.Is(err, downloader.ErrSpotifyNotFound):
+		return apiError(e, http.StatusNotFound, "spotify_track_not_found", "Spotify track not found")
+	case errors.Is(err, downloader.ErrMetadataNotFound):
+		return apiError(e, http.StatusNotFound, "track_not_found", "Track not found")
+	case errors.Is(err, downloader.ErrSpotifyAuth):
+		return apiError(e, http.StatusBadGateway, "spotify_auth_error", "Failed to authenticate with Spotify")
+	case errors.Is(err, downloader.ErrUpstream):
+		return apiError(e, http.StatusBadGateway, "upstream_error", "Failed to fetch track metadata")
+	default:
+		return apiError(e, http.StatusInternalServerError, "internal_error", "Failed to queue track")
+	}
+}
@@ -0,0 +1,346 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"api.groovio/downloader"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Webhook events
+const (
+	EventQueued    = "queued"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+	// Only sent through the test-delivery endpoint
+	EventPing = "ping"
+)
+
+// Timeout of a single delivery request
+const deliveryTimeout = 10 * time.Second
+
+// Freshly created deliveries are sent right away - the cron only picks them
+// up if they are still pending after this long (so they aren't sent twice)
+const deliveryGracePeriod = time.Minute
+
+// Payload is the JSON body POSTed to the webhook URL
+type Payload struct {
+	Event     string         `json:"event"`
+	CreatedAt string         `json:"created_at"`
+	Track     map[string]any `json:"track,omitempty"`
+}
+
+// Dispatcher creates delivery records for track transitions and sends them
+type Dispatcher struct {
+	app    core.App
+	client *http.Client
+
+	// Decides when failed deliveries are retried
+	Retry downloader.RetryPolicy
+
+	// last seen status of the in-progress tracks, keyed by track record id
+	seenMu sync.Mutex
+	seen   map[string]string
+
+	// held by the running DeliverPending, so overlapping cron runs don't send
+	// the same deliveries twice
+	pendingMu sync.Mutex
+}
+
+func NewDispatcher(app core.App) *Dispatcher {
+	return &Dispatcher{
+		app:    app,
+		client: &http.Client{Timeout: deliveryTimeout},
+		Retry:  downloader.DefaultRetryPolicy,
+		seen:   make(map[string]string),
+	}
+}
+
+// ======================================================================
+//  TRACK TRANSITIONS
+// ======================================================================
+
+// eventForStatus maps a track download_status to a webhook event ("" = no event)
+func eventForStatus(status string) string {
+	switch status {
+	case "queued":
+		return EventQueued
+	case "completed":
+		return EventCompleted
+	case "failed", "dead":
+		return EventFailed
+	}
+	return ""
+}
+
+// isFinalStatus reports if the track is done (for now) with downloading
+func isFinalStatus(status string) bool {
+	switch status {
+	case "queued", "downloading":
+		return false
+	}
+	return true
+}
+
+// HandleTrackChange enqueues deliveries if the track transitioned into a
+// status that webhooks can subscribe to
+func (d *Dispatcher) HandleTrackChange(track *core.Record) {
+	status := track.GetString("download_status")
+
+	// the in-memory record of a download is saved multiple times, so its
+	// original state can be stale - compare with the last status we saw instead.
+	// Final statuses are forgotten, the original state is accurate again afterwards.
+	d.seenMu.Lock()
+	last, ok := d.seen[track.Id]
+	if isFinalStatus(status) {
+		delete(d.seen, track.Id)
+	} else {
+		d.seen[track.Id] = status
+	}
+	d.seenMu.Unlock()
+
+	if !ok {
+		last = track.Original().GetString("download_status")
+	}
+
+	event := eventForStatus(status)
+	if event == "" {
+		return
+	}
+
+	// not a transition (e.g. a progress or metadata update)
+	if last == status {
+		return
+	}
+
+	if err := d.Enqueue(event, trackPayload(track)); err != nil {
+		log.Printf("Failed to enqueue %s webhooks for track %s: %v", event, track.Id, err)
+	}
+}
+
+// ForgetTrack drops the remembered status of a (deleted) track
+func (d *Dispatcher) ForgetTrack(track *core.Record) {
+	d.seenMu.Lock()
+	delete(d.seen, track.Id)
+	d.seenMu.Unlock()
+}
+
+func trackPayload(track *core.Record) map[string]any {
+	return map[string]any{
+		"id":               track.Id,
+		"spotify_track_id": track.GetString("spotify_track_id"),
+		"provider":         track.GetString("provider"),
+		"provider_id":      track.GetString("provider_id"),
+		"name":             track.GetString("name"),
+		"artist":           track.GetString("artist"),
+		"album":            track.GetString("album"),
+		"download_status":  track.GetString("download_status"),
+		"last_error":       track.GetString("last_error"),
+	}
+}
+
+// Enqueue creates a delivery for every active webhook subscribed to the event
+// and sends them in the background
+func (d *Dispatcher) Enqueue(event string, track map[string]any) error {
+	hooks, err := d.app.FindRecordsByFilter("webhooks", "active=true", "", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if !slices.Contains(hook.GetStringSlice("events"), event) {
+			continue
+		}
+
+		delivery, err := d.createDelivery(hook, event, track)
+		if err != nil {
+			log.Printf("Failed to create webhook delivery for webhook %s: %v", hook.Id, err)
+			continue
+		}
+
+		go d.deliver(delivery)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) createDelivery(hook *core.Record, event string, track map[string]any) (*core.Record, error) {
+	col, err := d.app.FindCollectionByNameOrId("webhook_deliveries")
+	if err != nil {
+		return nil, err
+	}
+
+	payload := Payload{
+		Event:     event,
+		CreatedAt: types.NowDateTime().String(),
+		Track:     track,
+	}
+
+	delivery := core.NewRecord(col)
+	delivery.Set("webhook", hook.Id)
+	delivery.Set("event", event)
+	delivery.Set("payload", payload)
+	delivery.Set("status", "pending")
+	delivery.Set("next_attempt_at", types.NowDateTime().Add(deliveryGracePeriod))
+
+	if err := d.app.Save(delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// ======================================================================
+//  DELIVERY
+// ======================================================================
+
+// DeliverPending sends every pending delivery that is due (used by the cron).
+// It is a no-op while a previous run is still going - with slow endpoints a
+// run can take longer than the cron interval.
+func (d *Dispatcher) DeliverPending() {
+	if !d.pendingMu.TryLock() {
+		return
+	}
+	defer d.pendingMu.Unlock()
+
+	deliveries, err := d.app.FindRecordsByFilter(
+		"webhook_deliveries",
+		"status='pending' && next_attempt_at<=@now",
+		"created",
+		100,
+		0,
+	)
+	if err != nil {
+		log.Println("Failed to load pending webhook deliveries:", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		d.deliver(delivery)
+	}
+}
+
+// deliver makes a single delivery attempt and records its outcome
+func (d *Dispatcher) deliver(delivery *core.Record) error {
+	attempts := delivery.GetInt("attempts") + 1
+	delivery.Set("attempts", attempts)
+
+	status, err := d.send(delivery)
+	delivery.Set("response_status", status)
+
+	switch {
+	case err == nil:
+		delivery.Set("status", "succeeded")
+		delivery.Set("last_error", "")
+		delivery.Set("next_attempt_at", "")
+	case d.Retry.Exhausted(attempts):
+		delivery.Set("status", "failed")
+		delivery.Set("last_error", err.Error())
+		delivery.Set("next_attempt_at", "")
+	default:
+		delivery.Set("last_error", err.Error())
+		delivery.Set("next_attempt_at", types.NowDateTime().Add(d.Retry.Backoff(attempts)))
+	}
+
+	if saveErr := d.app.Save(delivery); saveErr != nil {
+		log.Printf("Failed to save webhook delivery %s: %v", delivery.Id, saveErr)
+	}
+
+	return err
+}
+
+// send POSTs the HMAC signed payload and returns the response status code
+func (d *Dispatcher) send(delivery *core.Record) (int, error) {
+	hook, err := d.app.FindRecordById("webhooks", delivery.GetString("webhook"))
+	if err != nil {
+		return 0, fmt.Errorf("webhook not found: %w", err)
+	}
+
+	body := []byte(delivery.GetString("payload"))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", hook.GetString("url"), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.GetString("event"))
+	req.Header.Set("X-Webhook-Delivery", delivery.Id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if secret := hook.GetString("secret"); secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>".
+// Receivers should recompute it with their secret and compare.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ======================================================================
+//  TEST DELIVERY
+// ======================================================================
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// SendTest synchronously delivers a "ping" event to the webhook and returns the delivery record
+func (d *Dispatcher) SendTest(webhookID string) (*core.Record, error) {
+	hook, err := d.app.FindRecordById("webhooks", webhookID)
+	if err != nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	delivery, err := d.createDelivery(hook, EventPing, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// a failed ping is not retried, the result is reported back right away
+	status, err := d.send(delivery)
+	delivery.Set("attempts", 1)
+	delivery.Set("response_status", status)
+	delivery.Set("next_attempt_at", "")
+	if err != nil {
+		delivery.Set("status", "failed")
+		delivery.Set("last_error", err.Error())
+	} else {
+		delivery.Set("status", "succeeded")
+	}
+
+	if err := d.app.Save(delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
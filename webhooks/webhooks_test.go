@@ -0,0 +1,76 @@
+package webhooks
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			"ping",
+			"secret", "1700000000", `{"event":"ping"}`,
+			"4d39bd2442f073b6bc62e95d0297ce25475582a17389ab860abdc778fe1d9f77",
+		},
+		{
+			"other secret",
+			"other", "1700000000", `{"event":"ping"}`,
+			"0b745d77e8146ca45a844ad89177fce05067c8ba592d8a848a1837088acdd617",
+		},
+		{
+			"empty body",
+			"secret", "1700000000", "",
+			"4bc5f74d868b97888288889c5d9d65df02526f94c1592a79fdf4fe8b26e311e5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignTimestampIsSigned(t *testing.T) {
+	body := []byte(`{"event":"ping"}`)
+
+	// a replayed body with a new timestamp must not verify
+	if Sign("secret", "1700000000", body) == Sign("secret", "1700000001", body) {
+		t.Fatal("signature doesn't depend on the timestamp")
+	}
+}
+
+func TestEventForStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   string
+	}{
+		{"queued", EventQueued},
+		{"completed", EventCompleted},
+		{"failed", EventFailed},
+		{"dead", EventFailed},
+		{"downloading", ""},
+		{"cancelled", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := eventForStatus(tt.status); got != tt.want {
+			t.Errorf("eventForStatus(%q) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestDeliverPendingSkipsOverlappingRuns(t *testing.T) {
+	// a nil app would panic if the run wasn't skipped
+	d := &Dispatcher{}
+	d.pendingMu.Lock()
+	defer d.pendingMu.Unlock()
+
+	d.DeliverPending()
+}